@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// backupHandler serves GET /admin/backup as a tar archive of the segments.
+// The status is already sent when the archive is being written, so a failure
+// halfway can only be logged. The archive then lacks its end entry and
+// datastore.Restore rejects it.
+func backupHandler(db *datastore.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// A large archive takes longer than the write timeout of the server.
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", `attachment; filename="backup.tar"`)
+		if err := db.Backup(w); err != nil {
+			log.Printf("Backup failed: %s", err)
+		}
+	}
+}
+
+type cacheStats struct {
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Entries  int    `json:"entries"`
+	Size     int64  `json:"size"`
+	Capacity int64  `json:"capacity"`
+}
+
+// cacheStatsHandler serves GET /admin/cache with the value cache counters.
+func cacheStatsHandler(db *datastore.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := db.CacheStats()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cacheStats{s.Hits, s.Misses, s.Entries, s.Size, s.Capacity})
+	}
+}
+
+type engineStats struct {
+	Keys int   `json:"keys"`
+	Size int64 `json:"size"`
+}
+
+// statsHandler serves GET /admin/stats with the number of keys and the size
+// of the data.
+func statsHandler(db datastore.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := db.Stats()
+		if err != nil {
+			http.Error(w, "stats error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(engineStats{s.Keys, s.Size})
+	}
+}
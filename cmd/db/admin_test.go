@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupHandler(t *testing.T) {
+	db := openTestDB(t)
+	require.NoError(t, db.Put("team", "value"))
+
+	rr := httptest.NewRecorder()
+	backupHandler(db)(rr, httptest.NewRequest(http.MethodGet, "/admin/backup", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-tar", rr.Header().Get("Content-Type"))
+
+	dir := t.TempDir()
+	require.NoError(t, datastore.Restore(dir, rr.Body))
+	restored, err := datastore.Open(dir)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	got, err := restored.Get("team")
+	require.NoError(t, err)
+	assert.Equal(t, "value", got)
+}
+
+func TestCacheStatsHandler(t *testing.T) {
+	db, err := datastore.OpenWithOptions(t.TempDir(), datastore.WithCacheSize(1024))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	require.NoError(t, db.Put("team", "value"))
+	for i := 0; i < 2; i++ {
+		_, err := db.Get("team")
+		require.NoError(t, err)
+	}
+
+	rr := httptest.NewRecorder()
+	cacheStatsHandler(db)(rr, httptest.NewRequest(http.MethodGet, "/admin/cache", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"hits":1,"misses":1,"entries":1,"size":9,"capacity":1024}`, rr.Body.String())
+}
+
+func TestStatsHandler(t *testing.T) {
+	db := openTestEngine(t)
+	require.NoError(t, db.Put("team", "value"))
+	require.NoError(t, db.Put("a", "b"))
+
+	rr := httptest.NewRecorder()
+	statsHandler(db)(rr, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"keys":2,"size":11}`, rr.Body.String())
+}
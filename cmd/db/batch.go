@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+type batchOp struct {
+	Op  string `json:"op"`
+	Key string `json:"key"`
+	valueBody
+}
+
+// batchHandler applies a JSON array of put and delete operations atomically.
+func batchHandler(db datastore.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ops []batchOp
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		batch := db.NewBatch()
+		for _, op := range ops {
+			if op.Key == "" {
+				http.Error(w, "missing key", http.StatusBadRequest)
+				return
+			}
+			switch op.Op {
+			case "put":
+				value, err := op.decode()
+				if err != nil {
+					http.Error(w, "bad value: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				batch.PutValue(op.Key, value)
+			case "delete":
+				batch.Delete(op.Key)
+			default:
+				http.Error(w, "unknown operation: "+op.Op, http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := batch.Commit(); err != nil {
+			http.Error(w, "batch error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
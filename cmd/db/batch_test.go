@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T) *datastore.Database {
+	t.Helper()
+	db, err := datastore.Open(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+// openTestEngine returns an in-memory engine for the handlers that do not
+// need the segment files.
+func openTestEngine(t *testing.T) datastore.Engine {
+	t.Helper()
+	db := datastore.NewMemoryEngine()
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func TestBatchHandler(t *testing.T) {
+	db := openTestEngine(t)
+	require.NoError(t, db.Put("old", "value"))
+
+	body := `[{"op":"put","key":"a","value":"1"},{"op":"put","key":"b","value":"2"},{"op":"delete","key":"old"}]`
+	rr := httptest.NewRecorder()
+	batchHandler(db)(rr, httptest.NewRequest(http.MethodPost, "/db/_batch", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	value, err := db.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", value)
+	value, err = db.Get("b")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", value)
+	_, err = db.Get("old")
+	assert.ErrorIs(t, err, datastore.ErrKeyMissing)
+}
+
+func TestBatchHandler_BadOperation(t *testing.T) {
+	db := openTestEngine(t)
+
+	body := `[{"op":"put","key":"a","value":"1"},{"op":"rename","key":"b"}]`
+	rr := httptest.NewRecorder()
+	batchHandler(db)(rr, httptest.NewRequest(http.MethodPost, "/db/_batch", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	_, err := db.Get("a")
+	assert.ErrorIs(t, err, datastore.ErrKeyMissing, "nothing must be applied from a rejected batch")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// Every setting can be given either as a flag or as an environment variable.
+// Flags take precedence.
+var (
+	engineName    = flag.String("engine", envString("DB_ENGINE", "log"), "storage engine: log, or memory that keeps nothing on disk (DB_ENGINE)")
+	dbDir         = flag.String("dir", envString("DB_DIR", "./data"), "directory with the segment files (DB_DIR)")
+	segmentSize   = flag.Int64("segment-size", envInt64("DB_SEGMENT_SIZE", 10*1024*1024), "segment size in bytes (DB_SEGMENT_SIZE)")
+	readers       = flag.Int("readers", int(envInt64("DB_READERS", 10)), "number of concurrent readers (DB_READERS)")
+	queueDepth    = flag.Int("queue-depth", int(envInt64("DB_QUEUE_DEPTH", 100)), "read and write queue depth (DB_QUEUE_DEPTH)")
+	fileMode      = flag.String("file-mode", envString("DB_FILE_MODE", "0600"), "permissions of new segment files (DB_FILE_MODE)")
+	syncMode      = flag.String("sync", envString("DB_SYNC", "none"), "when writes are flushed to disk: none, always, batch or periodic (DB_SYNC)")
+	syncInterval  = flag.Duration("sync-interval", envDuration("DB_SYNC_INTERVAL", time.Second), "fsync interval for the periodic sync mode (DB_SYNC_INTERVAL)")
+	sweepInterval = flag.Duration("sweep-interval", envDuration("DB_SWEEP_INTERVAL", time.Minute), "how often expired keys are dropped from the index (DB_SWEEP_INTERVAL)")
+	compress      = flag.Int("compress", int(envInt64("DB_COMPRESS", 0)), "compress values of at least this many bytes, 0 disables (DB_COMPRESS)")
+	cacheSize     = flag.Int64("cache-size", envInt64("DB_CACHE_SIZE", 0), "bytes of recently read values kept in memory, 0 disables (DB_CACHE_SIZE)")
+	indexMode     = flag.String("index", envString("DB_INDEX", "memory"), "where the key index is kept: memory or disk (DB_INDEX)")
+	indexCache    = flag.Int64("index-cache", envInt64("DB_INDEX_CACHE", 16*1024*1024), "bytes of the disk index cached in memory (DB_INDEX_CACHE)")
+
+	leader              = flag.String("leader", envString("DB_LEADER", ""), "URL of the leader to follow, empty for a leader (DB_LEADER)")
+	replicationInterval = flag.Duration("replication-interval", envDuration("DB_REPLICATION_INTERVAL", 500*time.Millisecond), "how often a caught up follower polls the leader (DB_REPLICATION_INTERVAL)")
+
+	shards = flag.String("shards", envString("DB_SHARDS", ""), "comma separated host:port of all nodes to shard keys over, empty disables sharding (DB_SHARDS)")
+	self   = flag.String("self", envString("DB_SELF", ""), "host:port of this node in -shards (DB_SELF)")
+
+	compactSegments = flag.Int("compact-segments", int(envInt64("DB_COMPACT_SEGMENTS", 4)), "compact once this many sealed segments exist, 0 disables (DB_COMPACT_SEGMENTS)")
+	compactGarbage  = flag.Float64("compact-garbage", envFloat("DB_COMPACT_GARBAGE", 0.5), "compact once this share of sealed data is garbage, 0 disables (DB_COMPACT_GARBAGE)")
+)
+
+func envString(name, def string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+	return def
+}
+
+func envInt64(name string, def int64) int64 {
+	value := envString(name, "")
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+	return n
+}
+
+func envFloat(name string, def float64) float64 {
+	value := envString(name, "")
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+	return f
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	value := envString(name, "")
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+	return d
+}
+
+// dbOptions converts the parsed flags into datastore options.
+func dbOptions() ([]datastore.Option, error) {
+	mode, err := datastore.ParseSyncMode(*syncMode)
+	if err != nil {
+		return nil, err
+	}
+	perm, err := strconv.ParseUint(*fileMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file mode %q", *fileMode)
+	}
+
+	opts := []datastore.Option{
+		datastore.WithSegmentSize(*segmentSize),
+		datastore.WithReaders(*readers),
+		datastore.WithQueueDepth(*queueDepth),
+		datastore.WithFileMode(os.FileMode(perm)),
+		datastore.WithSync(mode, *syncInterval),
+		datastore.WithSweepInterval(*sweepInterval),
+		datastore.WithCompression(*compress),
+		datastore.WithCacheSize(*cacheSize),
+		datastore.WithCompaction(datastore.CompactionConfig{
+			MaxSegments:  *compactSegments,
+			GarbageRatio: *compactGarbage,
+		}),
+	}
+	switch *indexMode {
+	case "memory":
+	case "disk":
+		opts = append(opts, datastore.WithDiskIndex(*indexCache))
+	default:
+		return nil, fmt.Errorf("unknown index mode %q", *indexMode)
+	}
+	return opts, nil
+}
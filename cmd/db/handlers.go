@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+type entryResponse struct {
+	Key string `json:"key"`
+	valueBody
+}
+
+// postBody is the body of POST /db/{key}. A positive ttl_seconds makes the
+// key expire after that many seconds.
+type postBody struct {
+	valueBody
+	TTLSeconds int64 `json:"ttl_seconds"`
+}
+
+// keyHandler serves reads and writes of a single key under /db/{key}.
+func keyHandler(db datastore.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path[len("/db/"):]
+		if r.Method == http.MethodPost && strings.HasSuffix(key, incrSuffix) {
+			incrHandler(db, strings.TrimSuffix(key, incrSuffix), w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			putHandler(db, key, w, r)
+
+		case http.MethodPost:
+			var body postBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			value, err := body.decode()
+			if err != nil {
+				http.Error(w, "bad value: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if body.TTLSeconds < 0 {
+				http.Error(w, "bad ttl_seconds", http.StatusBadRequest)
+				return
+			}
+			if body.TTLSeconds > 0 {
+				err = db.PutValueTTL(key, value, time.Duration(body.TTLSeconds)*time.Second)
+			} else {
+				err = db.PutValue(key, value)
+			}
+			if err != nil {
+				http.Error(w, "put error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+
+		case http.MethodGet:
+			value, seq, err := db.GetWithVersion(key)
+			if err == datastore.ErrKeyMissing {
+				http.NotFound(w, r)
+				return
+			} else if err != nil {
+				http.Error(w, "get error", http.StatusInternalServerError)
+				return
+			}
+			etag := `"` + strconv.FormatUint(seq, 10) + `"`
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			body, err := encodeValue(value)
+			if err != nil {
+				http.Error(w, "get error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(entryResponse{key, body})
+
+		case http.MethodDelete:
+			err := db.Delete(key)
+			if err == datastore.ErrKeyMissing {
+				http.NotFound(w, r)
+				return
+			} else if err != nil {
+				http.Error(w, "delete error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+const incrSuffix = "/incr"
+
+// incrHandler serves POST /db/{key}/incr with an optional {"delta": n} body.
+func incrHandler(db datastore.Engine, key string, w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Delta *int64 `json:"delta"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	delta := int64(1)
+	if body.Delta != nil {
+		delta = *body.Delta
+	}
+
+	n, err := db.Increment(key, delta)
+	if errors.Is(err, datastore.ErrWrongType) || errors.Is(err, datastore.ErrOverflow) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "incr error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entryResponse{key, valueBody{
+		Type:  datastore.TypeInt64.String(),
+		Value: json.RawMessage(strconv.FormatInt(n, 10)),
+	}})
+}
+
+// headerIfValue makes a PUT conditional on the current value instead of the
+// version.
+const headerIfValue = "X-If-Value"
+
+// putHandler serves PUT /db/{key}. With an If-Match header holding the ETag
+// returned by GET it only writes if the key has not been written since. With
+// an X-If-Value header it only writes if the key currently holds the value
+// given there, read with the type of the new value.
+func putHandler(db datastore.Engine, key string, w http.ResponseWriter, r *http.Request) {
+	var body valueBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	value, err := body.decode()
+	if err != nil {
+		http.Error(w, "bad value: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if etag, ok := r.Header["If-Match"]; ok {
+		version, parseErr := strconv.ParseUint(strings.Trim(etag[0], `"`), 10, 64)
+		if parseErr != nil {
+			http.Error(w, "bad If-Match: "+parseErr.Error(), http.StatusBadRequest)
+			return
+		}
+		err = db.CompareVersionAndSwap(key, version, value)
+	} else if expected, ok := r.Header[headerIfValue]; ok {
+		old, parseErr := parseHeaderValue(expected[0], value.Type)
+		if parseErr != nil {
+			http.Error(w, "bad "+headerIfValue+": "+parseErr.Error(), http.StatusBadRequest)
+			return
+		}
+		err = db.CompareAndSwap(key, old, value)
+	} else {
+		err = db.PutValue(key, value)
+	}
+
+	switch {
+	case err == datastore.ErrKeyMissing:
+		http.NotFound(w, r)
+	case err == datastore.ErrConflict:
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+	case err != nil:
+		http.Error(w, "put error", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyHandler_TypedValues(t *testing.T) {
+	db := openTestEngine(t)
+	handler := keyHandler(db)
+
+	for _, tc := range []struct {
+		key, body, want string
+	}{
+		{"str", `{"value":"text"}`, `{"key":"str","type":"string","value":"text"}`},
+		{"num", `{"type":"int64","value":42}`, `{"key":"num","type":"int64","value":42}`},
+		{"raw", `{"type":"bytes","value":"AAH/"}`, `{"key":"raw","type":"bytes","value":"AAH/"}`},
+	} {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodPost, "/db/"+tc.key, strings.NewReader(tc.body)))
+		require.Equal(t, http.StatusOK, rr.Code, tc.key)
+
+		rr = httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodGet, "/db/"+tc.key, nil))
+		require.Equal(t, http.StatusOK, rr.Code, tc.key)
+		assert.JSONEq(t, tc.want, rr.Body.String())
+	}
+
+	value, err := db.GetValue("num")
+	require.NoError(t, err)
+	n, err := value.AsInt64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+}
+
+func TestKeyHandler_BadValue(t *testing.T) {
+	db := openTestEngine(t)
+	handler := keyHandler(db)
+
+	for _, body := range []string{
+		`{"type":"int64","value":"42"}`,
+		`{"type":"float","value":1.5}`,
+		`{"type":"string"}`,
+	} {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodPost, "/db/key", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
+
+func TestKeyHandler_StringReplyForServer(t *testing.T) {
+	db := openTestEngine(t)
+	require.NoError(t, db.Put("team", "2026-10-17"))
+
+	rr := httptest.NewRecorder()
+	keyHandler(db)(rr, httptest.NewRequest(http.MethodGet, "/db/team", nil))
+
+	// cmd/server decodes the reply into this struct.
+	var entry struct{ Key, Value string }
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&entry))
+	assert.Equal(t, "team", entry.Key)
+	assert.Equal(t, "2026-10-17", entry.Value)
+}
+
+func TestKeyHandler_Incr(t *testing.T) {
+	db := openTestEngine(t)
+	handler := keyHandler(db)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/db/team/hits/incr", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"key":"team/hits","type":"int64","value":1}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/db/team/hits/incr", strings.NewReader(`{"delta":10}`)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"key":"team/hits","type":"int64","value":11}`, rr.Body.String())
+
+	require.NoError(t, db.Put("text", "abc"))
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/db/text/incr", nil))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	require.NoError(t, db.PutValue("max", datastore.Int64Value(math.MaxInt64)))
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/db/max/incr", nil))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestKeyHandler_ConditionalPut(t *testing.T) {
+	db := openTestEngine(t)
+	handler := keyHandler(db)
+	require.NoError(t, db.PutValue("num", datastore.Int64Value(1)))
+	_, version, err := db.GetWithVersion("num")
+	require.NoError(t, err)
+
+	put := func(header, condition, body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/db/num", strings.NewReader(body))
+		if header != "" {
+			req.Header.Set(header, condition)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+	etag := func(version uint64) string {
+		return `"` + strconv.FormatUint(version, 10) + `"`
+	}
+
+	// The ETag names a version, a value that happens to look the same must
+	// not pass for it.
+	stale := version
+	require.NoError(t, db.PutValue("num", datastore.Int64Value(int64(stale))))
+	_, version, err = db.GetWithVersion("num")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, put("If-Match", etag(stale), `{"type":"int64","value":2}`))
+	assert.Equal(t, http.StatusOK, put("If-Match", etag(version), `{"type":"int64","value":2}`))
+	assert.Equal(t, http.StatusPreconditionFailed, put("If-Match", etag(version), `{"type":"int64","value":3}`))
+	assert.Equal(t, http.StatusBadRequest, put("If-Match", `"v1"`, `{"type":"int64","value":3}`))
+
+	assert.Equal(t, http.StatusPreconditionFailed, put(headerIfValue, "5", `{"type":"int64","value":3}`))
+	assert.Equal(t, http.StatusOK, put(headerIfValue, "2", `{"type":"int64","value":3}`))
+	value, err := db.GetValue("num")
+	require.NoError(t, err)
+	n, err := value.AsInt64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	assert.Equal(t, http.StatusOK, put("", "", `{"value":"plain"}`))
+	plain, err := db.Get("num")
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", plain)
+}
+
+func TestKeyHandler_ETag(t *testing.T) {
+	db := openTestEngine(t)
+	handler := keyHandler(db)
+	require.NoError(t, db.Put("key", "v1"))
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/db/key", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	rr := get("")
+	require.Equal(t, http.StatusOK, rr.Code)
+	etag := rr.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	rr = get(etag)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Empty(t, rr.Body.String())
+
+	require.NoError(t, db.Put("key", "v2"))
+	rr = get(etag)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+}
+
+func TestKeyHandler_TTL(t *testing.T) {
+	db := openTestEngine(t)
+	handler := keyHandler(db)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/db/session", strings.NewReader(`{"value":"data","ttl_seconds":1}`)))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/db/session", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	time.Sleep(1100 * time.Millisecond)
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/db/session", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/db/session", strings.NewReader(`{"value":"data","ttl_seconds":-1}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
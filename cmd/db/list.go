@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type listItem struct {
+	entryResponse
+	// TTLSeconds is how many seconds are left until the key expires,
+	// rounded up. It is left out for keys that never expire.
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+}
+
+type listPage struct {
+	Items []listItem `json:"items"`
+	// NextCursor is passed back as the cursor parameter to get the next page.
+	// It is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// listHandler serves GET /db?prefix=&limit=&cursor= with keys in ascending
+// order. The cursor is the last key of the previous page. Keys that expire
+// carry the seconds they have left, so copies can keep the expiry.
+func listHandler(db datastore.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		prefix := query.Get("prefix")
+		cursor := query.Get("cursor")
+
+		limit := defaultListLimit
+		if s := query.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "bad limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxListLimit)
+		}
+
+		start := prefix
+		if cursor != "" && cursor >= start {
+			start = cursor + "\x00"
+		}
+
+		page := listPage{Items: []listItem{}}
+		for key, value := range db.Scan(start, datastore.PrefixEnd(prefix)) {
+			if len(page.Items) == limit {
+				page.NextCursor = page.Items[len(page.Items)-1].Key
+				break
+			}
+			expiresAt, err := db.ExpiresAt(key)
+			if err == datastore.ErrKeyMissing {
+				// Deleted or expired since it was read.
+				continue
+			}
+			if err != nil {
+				http.Error(w, "list error", http.StatusInternalServerError)
+				return
+			}
+			body, err := encodeValue(value)
+			if err != nil {
+				http.Error(w, "list error", http.StatusInternalServerError)
+				return
+			}
+			item := listItem{entryResponse: entryResponse{key, body}}
+			if !expiresAt.IsZero() {
+				left := time.Until(expiresAt)
+				if left <= 0 {
+					continue
+				}
+				item.TTLSeconds = int64((left + time.Second - 1) / time.Second)
+			}
+			page.Items = append(page.Items, item)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stringItem(key, value string) listItem {
+	raw, _ := json.Marshal(value)
+	return listItem{entryResponse: entryResponse{key, valueBody{"string", raw}}}
+}
+
+func TestListHandler_Pagination(t *testing.T) {
+	db := openTestEngine(t)
+	for _, key := range []string{"team/a", "team/b", "team/c", "other"} {
+		require.NoError(t, db.Put(key, "v-"+key))
+	}
+
+	get := func(url string) listPage {
+		rr := httptest.NewRecorder()
+		listHandler(db)(rr, httptest.NewRequest(http.MethodGet, url, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		var page listPage
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+		return page
+	}
+
+	page := get("/db?prefix=team/&limit=2")
+	assert.Equal(t, []listItem{stringItem("team/a", "v-team/a"), stringItem("team/b", "v-team/b")}, page.Items)
+	assert.Equal(t, "team/b", page.NextCursor)
+
+	page = get("/db?prefix=team/&limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, []listItem{stringItem("team/c", "v-team/c")}, page.Items)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestListHandler_TTL(t *testing.T) {
+	db := openTestEngine(t)
+	require.NoError(t, db.PutValueTTL("session", datastore.StringValue("data"), 90*time.Second))
+	require.NoError(t, db.Put("user", "data"))
+
+	rr := httptest.NewRecorder()
+	listHandler(db)(rr, httptest.NewRequest(http.MethodGet, "/db", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"items":[
+		{"key":"session","type":"string","value":"data","ttl_seconds":90},
+		{"key":"user","type":"string","value":"data"}
+	]}`, rr.Body.String())
+}
+
+func TestListHandler_BadLimit(t *testing.T) {
+	db := openTestEngine(t)
+	rr := httptest.NewRecorder()
+	listHandler(db)(rr, httptest.NewRequest(http.MethodGet, "/db?limit=abc", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
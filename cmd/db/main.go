@@ -1,11 +1,9 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"log"
 	"net/http"
-	"os"
 
 	"github.com/roman-mazur/architecture-practice-4-template/datastore"
 	"github.com/roman-mazur/architecture-practice-4-template/httptools"
@@ -16,15 +14,14 @@ var port = flag.Int("port", 8082, "db HTTP port")
 func main() {
 	flag.Parse()
 
-	dbDir := os.Getenv("DB_DIR")
-	if dbDir == "" {
-		dbDir = "./data"
+	opts, err := dbOptions()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
 	}
-	db, err := datastore.Open(dbDir)
+	rt, err := newRouter(*shards, *self)
 	if err != nil {
-		log.Fatalf("failed to open DB: %v", err)
+		log.Fatalf("invalid configuration: %v", err)
 	}
-	defer db.Close()
 
 	mux := http.NewServeMux()
 
@@ -33,40 +30,51 @@ func main() {
 		w.Write([]byte("ok"))
 	})
 
-	mux.HandleFunc("/db/", func(w http.ResponseWriter, r *http.Request) {
-		key := r.URL.Path[len("/db/"):]
-		switch r.Method {
-		case http.MethodPost:
-			var body struct {
-				Value string `json:"value"`
-			}
-			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-				http.Error(w, "bad request", http.StatusBadRequest)
-				return
-			}
-			if err := db.Put(key, body.Value); err != nil {
-				http.Error(w, "put error", http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(http.StatusOK)
+	// Watching, backups and replication work on the segment files, so they
+	// are only served by the log engine.
+	var engine datastore.Engine
+	var db *datastore.Database
+	switch *engineName {
+	case "log":
+		db, err = datastore.OpenWithOptions(*dbDir, opts...)
+		if err != nil {
+			log.Fatalf("failed to open DB: %v", err)
+		}
+		if stats := db.Recovery(); stats.Discarded > 0 {
+			log.Printf("Recovered %d bytes of damaged segments, discarded %d bytes", stats.Recovered, stats.Discarded)
+		}
+		engine = db
+	case "memory":
+		if *leader != "" {
+			log.Fatalf("invalid configuration: the memory engine cannot follow a leader")
+		}
+		engine = datastore.NewMemoryEngine()
+	default:
+		log.Fatalf("invalid configuration: unknown engine %q", *engineName)
+	}
+	defer engine.Close()
 
-		case http.MethodGet:
-			value, err := db.Get(key)
-			if err == datastore.ErrKeyMissing {
-				http.NotFound(w, r)
-				return
-			} else if err != nil {
-				http.Error(w, "get error", http.StatusInternalServerError)
-				return
-			}
-			resp := map[string]string{"key": key, "value": value}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(resp)
+	rep := newReplica(db, *leader, *dbDir, *replicationInterval)
+	rep.start()
+	if *leader != "" {
+		log.Printf("Following %s", *leader)
+	}
 
-		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	mux.HandleFunc("GET /db", listHandler(engine))
+	mux.HandleFunc("POST /db/_batch", readOnly(rep, rt.routeBatch(batchHandler(engine))))
+	mux.HandleFunc("GET /admin/stats", statsHandler(engine))
+	mux.HandleFunc("/db/", readOnly(rep, rt.route(keyHandler(engine))))
+
+	if db != nil {
+		mux.HandleFunc("GET /db/_watch", watchHandler(db))
+		mux.HandleFunc("GET /admin/backup", backupHandler(db))
+		mux.HandleFunc("GET /admin/cache", cacheStatsHandler(db))
+
+		mux.HandleFunc("GET /replication/log", logHandler(db))
+		mux.HandleFunc("GET /replication/snapshot", snapshotHandler(db))
+		mux.HandleFunc("GET /admin/replication", replicationStatusHandler(rep))
+		mux.HandleFunc("POST /admin/promote", promoteHandler(rep))
+	}
 
 	server := httptools.CreateServer(*port, mux)
 	log.Printf("Starting DB HTTP on :%d", *port)
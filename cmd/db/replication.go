@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// Replication headers carry log positions next to the raw records.
+const (
+	headerFormat      = "X-Log-Format"
+	headerNextSegment = "X-Next-Segment"
+	headerNextOffset  = "X-Next-Offset"
+
+	defaultLogLimit = 1 << 20
+	maxLogLimit     = 16 << 20
+
+	// logTimeout bounds a request for a log chunk, so a follower whose leader
+	// stopped answering reports the error instead of waiting for good. The
+	// snapshot request has no limit, it streams for as long as it takes.
+	logTimeout = 30 * time.Second
+
+	replicaStateFile = "replication.json"
+)
+
+// logHandler serves GET /replication/log?segment=&offset=&limit= with the
+// records that follow the position. 410 Gone tells the follower to start
+// over from /replication/snapshot.
+func logHandler(db *datastore.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		segment, err := strconv.Atoi(query.Get("segment"))
+		if err != nil {
+			http.Error(w, "bad segment", http.StatusBadRequest)
+			return
+		}
+		offset, err := strconv.ParseInt(query.Get("offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "bad offset", http.StatusBadRequest)
+			return
+		}
+		limit := defaultLogLimit
+		if s := query.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "bad limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxLogLimit)
+		}
+
+		chunk, err := db.ReadLog(datastore.LogPosition{Segment: segment, Offset: offset}, limit)
+		if err == datastore.ErrPositionGone {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		} else if err != nil {
+			http.Error(w, "log error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(headerFormat, strconv.Itoa(int(chunk.Version)))
+		setPosition(w.Header(), chunk.Next)
+		w.Write(chunk.Data)
+	}
+}
+
+// snapshotHandler serves GET /replication/snapshot with all live records and
+// the log position to continue from.
+func snapshotHandler(db *datastore.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := db.Snapshot()
+		defer snap.Release()
+
+		// Copying a large database takes longer than the write timeout of
+		// the server.
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		w.Header().Set("Content-Type", "application/octet-stream")
+		setPosition(w.Header(), snap.Position())
+		if err := snap.WriteRecords(w); err != nil {
+			log.Printf("Replication snapshot failed: %s", err)
+		}
+	}
+}
+
+func setPosition(h http.Header, pos datastore.LogPosition) {
+	h.Set(headerNextSegment, strconv.Itoa(pos.Segment))
+	h.Set(headerNextOffset, strconv.FormatInt(pos.Offset, 10))
+}
+
+func parsePosition(h http.Header) (datastore.LogPosition, error) {
+	segment, err := strconv.Atoi(h.Get(headerNextSegment))
+	if err != nil {
+		return datastore.LogPosition{}, fmt.Errorf("bad %s header", headerNextSegment)
+	}
+	offset, err := strconv.ParseInt(h.Get(headerNextOffset), 10, 64)
+	if err != nil {
+		return datastore.LogPosition{}, fmt.Errorf("bad %s header", headerNextOffset)
+	}
+	return datastore.LogPosition{Segment: segment, Offset: offset}, nil
+}
+
+// replicaState is saved in the data directory of a follower, so that it
+// resumes from the same position after a restart.
+type replicaState struct {
+	Leader  string `json:"leader"`
+	Segment int    `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// replica keeps a follower in sync with its leader until it is promoted.
+type replica struct {
+	db       *datastore.Database
+	leader   string
+	path     string
+	interval time.Duration
+	timeout  time.Duration
+	client   *http.Client
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu        sync.Mutex
+	following bool
+	synced    bool
+	pos       datastore.LogPosition
+	lastErr   error
+}
+
+// newReplica returns a replica of a node that is a leader when leader is
+// empty.
+func newReplica(db *datastore.Database, leader, dir string, interval time.Duration) *replica {
+	return &replica{
+		db:        db,
+		leader:    strings.TrimSuffix(leader, "/"),
+		path:      filepath.Join(dir, replicaStateFile),
+		interval:  interval,
+		timeout:   logTimeout,
+		client:    new(http.Client),
+		following: leader != "",
+	}
+}
+
+// start begins tailing the leader if the node is a follower.
+func (rep *replica) start() {
+	if !rep.following {
+		return
+	}
+	rep.loadState()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rep.cancel = cancel
+	rep.done = make(chan struct{})
+	go rep.run(ctx)
+}
+
+func (rep *replica) loadState() {
+	data, err := os.ReadFile(rep.path)
+	if err != nil {
+		return
+	}
+	var state replicaState
+	if err := json.Unmarshal(data, &state); err != nil || state.Leader != rep.leader {
+		return
+	}
+	rep.pos = datastore.LogPosition{Segment: state.Segment, Offset: state.Offset}
+	rep.synced = true
+}
+
+func (rep *replica) saveState() error {
+	data, err := json.Marshal(replicaState{rep.leader, rep.pos.Segment, rep.pos.Offset})
+	if err != nil {
+		return err
+	}
+	tmp := rep.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rep.path)
+}
+
+func (rep *replica) run(ctx context.Context) {
+	defer close(rep.done)
+
+	for {
+		caughtUp, err := rep.step(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		rep.mu.Lock()
+		rep.lastErr = err
+		rep.mu.Unlock()
+		if err != nil {
+			log.Printf("Replication from %s failed: %s", rep.leader, err)
+		}
+		if err == nil && !caughtUp {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(rep.interval):
+		}
+	}
+}
+
+// step copies one chunk of the leader log, or the whole snapshot if the
+// replica has no usable position. It reports whether there was nothing new.
+func (rep *replica) step(ctx context.Context) (bool, error) {
+	rep.mu.Lock()
+	synced, pos := rep.synced, rep.pos
+	rep.mu.Unlock()
+	if !synced {
+		return false, rep.resync(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, rep.timeout)
+	defer cancel()
+	query := url.Values{}
+	query.Set("segment", strconv.Itoa(pos.Segment))
+	query.Set("offset", strconv.FormatInt(pos.Offset, 10))
+	resp, err := rep.get(ctx, "/replication/log?"+query.Encode())
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		log.Printf("Replication position %+v is gone, copying a snapshot", pos)
+		rep.mu.Lock()
+		rep.synced = false
+		rep.mu.Unlock()
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	next, err := parsePosition(resp.Header)
+	if err != nil {
+		return false, err
+	}
+	version, err := strconv.ParseUint(resp.Header.Get(headerFormat), 10, 8)
+	if err != nil {
+		return false, fmt.Errorf("bad %s header", headerFormat)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if err := rep.db.ApplyLog(datastore.LogChunk{Version: byte(version), Data: data, Next: next}); err != nil {
+		return false, err
+	}
+	return next == pos, rep.advance(next)
+}
+
+// resync replaces the contents of the follower with a snapshot of the leader.
+func (rep *replica) resync(ctx context.Context) error {
+	resp, err := rep.get(ctx, "/replication/snapshot")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	pos, err := parsePosition(resp.Header)
+	if err != nil {
+		return err
+	}
+	if err := rep.db.ReplaceRecords(resp.Body); err != nil {
+		return err
+	}
+	return rep.advance(pos)
+}
+
+func (rep *replica) advance(pos datastore.LogPosition) error {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.pos = pos
+	rep.synced = true
+	return rep.saveState()
+}
+
+func (rep *replica) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rep.leader+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rep.client.Do(req)
+}
+
+// promote stops following the leader, so the node accepts writes. Records
+// the leader had not shipped yet are lost to the new leader.
+func (rep *replica) promote() error {
+	rep.mu.Lock()
+	following := rep.following
+	rep.mu.Unlock()
+	if !following {
+		return nil
+	}
+
+	rep.cancel()
+	<-rep.done
+	rep.mu.Lock()
+	rep.following = false
+	rep.mu.Unlock()
+	if err := os.Remove(rep.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (rep *replica) isFollower() bool {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return rep.following
+}
+
+type replicationStatus struct {
+	Role    string `json:"role"`
+	Leader  string `json:"leader,omitempty"`
+	Segment int    `json:"segment,omitempty"`
+	Offset  int64  `json:"offset,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (rep *replica) status() replicationStatus {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	if !rep.following {
+		return replicationStatus{Role: "leader"}
+	}
+	s := replicationStatus{Role: "follower", Leader: rep.leader, Segment: rep.pos.Segment, Offset: rep.pos.Offset}
+	if rep.lastErr != nil {
+		s.Error = rep.lastErr.Error()
+	}
+	return s
+}
+
+// readOnly rejects writes while the node follows a leader.
+func readOnly(rep *replica, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && rep.isFollower() {
+			http.Error(w, "read-only follower of "+rep.leader, http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// promoteHandler serves POST /admin/promote.
+func promoteHandler(rep *replica) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := rep.promote(); err != nil {
+			http.Error(w, "promote error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Promoted to leader")
+		replicationStatusHandler(rep)(w, r)
+	}
+}
+
+// replicationStatusHandler serves GET /admin/replication.
+func replicationStatusHandler(rep *replica) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rep.status())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplication(t *testing.T) {
+	leaderDB := openTestDB(t)
+	require.NoError(t, leaderDB.Put("before", "1"))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /replication/log", logHandler(leaderDB))
+	mux.HandleFunc("GET /replication/snapshot", snapshotHandler(leaderDB))
+	leader := httptest.NewServer(mux)
+	t.Cleanup(leader.Close)
+
+	followerDB := openTestDB(t)
+	rep := newReplica(followerDB, leader.URL, t.TempDir(), 10*time.Millisecond)
+	rep.start()
+	t.Cleanup(func() {
+		_ = rep.promote()
+	})
+
+	require.NoError(t, leaderDB.Put("after", "2"))
+	require.NoError(t, leaderDB.Delete("before"))
+	require.Eventually(t, func() bool {
+		got, err := followerDB.Get("after")
+		return err == nil && got == "2" && len(followerDB.Keys("")) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	handler := readOnly(rep, keyHandler(followerDB))
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/db/key", strings.NewReader(`{"value":"x"}`)))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/db/after", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	promoteHandler(rep)(rr, httptest.NewRequest(http.MethodPost, "/admin/promote", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"role":"leader"}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/db/key", strings.NewReader(`{"value":"x"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestReplication_LeaderHangs(t *testing.T) {
+	leaderDB := openTestDB(t)
+	require.NoError(t, leaderDB.Put("key", "1"))
+
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /replication/snapshot", snapshotHandler(leaderDB))
+	// The leader sends the headers of a log chunk and never the rest.
+	mux.HandleFunc("GET /replication/log", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerFormat, "1")
+		w.Header().Set(headerNextSegment, "0")
+		w.Header().Set(headerNextOffset, "0")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	leader := httptest.NewServer(mux)
+	t.Cleanup(leader.Close)
+	t.Cleanup(func() {
+		close(release)
+	})
+
+	rep := newReplica(openTestDB(t), leader.URL, t.TempDir(), 10*time.Millisecond)
+	rep.timeout = 50 * time.Millisecond
+	rep.start()
+	t.Cleanup(func() {
+		_ = rep.promote()
+	})
+
+	require.Eventually(t, func() bool {
+		return rep.status().Error != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, rep.status().Error, context.DeadlineExceeded.Error())
+}
+
+func TestLogHandler_Gone(t *testing.T) {
+	db := openTestDB(t)
+
+	rr := httptest.NewRecorder()
+	logHandler(db)(rr, httptest.NewRequest(http.MethodGet, "/replication/log?segment=42&offset=0", nil))
+	assert.Equal(t, http.StatusGone, rr.Code)
+
+	rr = httptest.NewRecorder()
+	logHandler(db)(rr, httptest.NewRequest(http.MethodGet, "/replication/log?segment=x", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
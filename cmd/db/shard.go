@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/roman-mazur/architecture-practice-4-template/shard"
+)
+
+// router forwards requests for keys that another node owns. A nil router
+// serves everything locally.
+type router struct {
+	ring   *shard.Ring
+	self   string
+	client *http.Client
+}
+
+// newRouter returns nil when nodes is empty, that is when sharding is off.
+func newRouter(nodes, self string) (*router, error) {
+	if nodes == "" {
+		return nil, nil
+	}
+	list := strings.Split(nodes, ",")
+	if !slices.Contains(list, self) {
+		return nil, errors.New("-self must be one of the -shards nodes")
+	}
+	return &router{shard.NewRing(list, 0), self, new(http.Client)}, nil
+}
+
+// local tells whether the request has to be served by this node.
+func (rt *router) local(r *http.Request, key string) bool {
+	return rt == nil || r.Header.Get(shard.ForwardedHeader) != "" || rt.ring.Node(key) == rt.self
+}
+
+// route wraps the handler of /db/{key}.
+func (rt *router) route(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path[len("/db/"):]
+		if r.Method == http.MethodPost {
+			key = strings.TrimSuffix(key, incrSuffix)
+		}
+		if rt.local(r, key) {
+			h(w, r)
+			return
+		}
+		rt.forward(rt.ring.Node(key), w, r)
+	}
+}
+
+// routeBatch wraps the batch handler. A batch is only atomic on one node, so
+// all of its keys must belong to the same one.
+func (rt *router) routeBatch(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rt == nil || r.Header.Get(shard.ForwardedHeader) != "" {
+			h(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var ops []batchOp
+		if err := json.Unmarshal(body, &ops); err != nil || len(ops) == 0 {
+			// The batch handler reports what is wrong with it.
+			h(w, r)
+			return
+		}
+		owner := rt.ring.Node(ops[0].Key)
+		for _, op := range ops[1:] {
+			if rt.ring.Node(op.Key) != owner {
+				http.Error(w, "batch keys belong to different shards", http.StatusBadRequest)
+				return
+			}
+		}
+		if owner == rt.self {
+			h(w, r)
+			return
+		}
+		rt.forward(owner, w, r)
+	}
+}
+
+func (rt *router) forward(dst string, w http.ResponseWriter, r *http.Request) {
+	fwdRequest := r.Clone(r.Context())
+	fwdRequest.RequestURI = ""
+	fwdRequest.URL.Host = dst
+	fwdRequest.URL.Scheme = "http"
+	fwdRequest.Host = dst
+	fwdRequest.Header.Set(shard.ForwardedHeader, rt.self)
+
+	resp, err := rt.client.Do(fwdRequest)
+	if err != nil {
+		log.Printf("Failed to forward to %s: %s", dst, err)
+		http.Error(w, "shard unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer resp.Body.Close()
+	for k, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(k, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("Failed to write response: %s", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/shard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startShards runs two nodes that route keys to each other.
+func startShards(t *testing.T) ([]*httptest.Server, []*datastore.Database) {
+	t.Helper()
+	var (
+		servers []*httptest.Server
+		dbs     []*datastore.Database
+		muxes   []*http.ServeMux
+	)
+	for i := 0; i < 2; i++ {
+		mux := http.NewServeMux()
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		servers = append(servers, srv)
+		dbs = append(dbs, openTestDB(t))
+		muxes = append(muxes, mux)
+	}
+
+	var nodes []string
+	for _, srv := range servers {
+		nodes = append(nodes, strings.TrimPrefix(srv.URL, "http://"))
+	}
+	for i, mux := range muxes {
+		rt, err := newRouter(strings.Join(nodes, ","), nodes[i])
+		require.NoError(t, err)
+		mux.HandleFunc("POST /db/_batch", rt.routeBatch(batchHandler(dbs[i])))
+		mux.HandleFunc("/db/", rt.route(keyHandler(dbs[i])))
+	}
+	return servers, dbs
+}
+
+func TestRouter(t *testing.T) {
+	servers, dbs := startShards(t)
+
+	var keys []string
+	for i := 0; i < 50; i++ {
+		keys = append(keys, fmt.Sprintf("key%d", i))
+	}
+	for _, key := range keys {
+		resp, err := http.Post(servers[0].URL+"/db/"+key, "application/json", strings.NewReader(`{"value":"v-`+key+`"}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// Every key is stored exactly once, and both nodes hold some of them.
+	local := [2]int{}
+	for _, key := range keys {
+		found := 0
+		for i, db := range dbs {
+			if _, err := db.Get(key); err == nil {
+				local[i]++
+				found++
+			}
+		}
+		assert.Equal(t, 1, found, key)
+
+		resp, err := http.Get(servers[1].URL + "/db/" + key)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, key)
+	}
+	assert.NotZero(t, local[0])
+	assert.NotZero(t, local[1])
+}
+
+func TestRouter_BatchAcrossShards(t *testing.T) {
+	servers, _ := startShards(t)
+
+	var nodes []string
+	for _, srv := range servers {
+		nodes = append(nodes, strings.TrimPrefix(srv.URL, "http://"))
+	}
+	ring := shard.NewRing(nodes, 0)
+	first, other := "key0", ""
+	for i := 1; other == ""; i++ {
+		if key := fmt.Sprintf("key%d", i); ring.Node(key) != ring.Node(first) {
+			other = key
+		}
+	}
+
+	body := fmt.Sprintf(`[{"op":"put","key":%q,"value":"1"},{"op":"put","key":%q,"value":"2"}]`, first, other)
+	resp, err := http.Post(servers[0].URL+"/db/_batch", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	body = fmt.Sprintf(`[{"op":"put","key":%q,"value":"1"}]`, other)
+	resp, err = http.Post(servers[0].URL+"/db/_batch", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestNewRouter_SelfMustBeListed(t *testing.T) {
+	_, err := newRouter("a:8082,b:8082", "c:8082")
+	assert.Error(t, err)
+
+	rt, err := newRouter("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, rt)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// valueBody is the JSON form of a typed value: {"type":"int64","value":42}.
+// Without a type the value is a string. Bytes are base64 encoded.
+type valueBody struct {
+	Type  string          `json:"type,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (b valueBody) decode() (datastore.Value, error) {
+	kind := datastore.TypeString
+	if b.Type != "" {
+		var err error
+		if kind, err = datastore.ParseValueType(b.Type); err != nil {
+			return datastore.Value{}, err
+		}
+	}
+	if b.Value == nil {
+		return datastore.Value{}, fmt.Errorf("missing value")
+	}
+
+	switch kind {
+	case datastore.TypeInt64:
+		var n int64
+		if err := json.Unmarshal(b.Value, &n); err != nil {
+			return datastore.Value{}, err
+		}
+		return datastore.Int64Value(n), nil
+	case datastore.TypeBytes:
+		var raw []byte
+		if err := json.Unmarshal(b.Value, &raw); err != nil {
+			return datastore.Value{}, err
+		}
+		return datastore.BytesValue(raw), nil
+	default:
+		var s string
+		if err := json.Unmarshal(b.Value, &s); err != nil {
+			return datastore.Value{}, err
+		}
+		return datastore.StringValue(s), nil
+	}
+}
+
+func encodeValue(v datastore.Value) (valueBody, error) {
+	var content any
+	var err error
+	switch v.Type {
+	case datastore.TypeInt64:
+		content, err = v.AsInt64()
+	case datastore.TypeBytes:
+		content, err = v.AsBytes()
+	default:
+		content, err = v.AsString()
+	}
+	if err != nil {
+		return valueBody{}, err
+	}
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return valueBody{}, err
+	}
+	return valueBody{Type: v.Type.String(), Value: raw}, nil
+}
+
+// parseHeaderValue reads a value of the given type from its plain text form:
+// decimal for int64 and base64 for bytes.
+func parseHeaderValue(s string, kind datastore.ValueType) (datastore.Value, error) {
+	switch kind {
+	case datastore.TypeInt64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return datastore.Value{}, err
+		}
+		return datastore.Int64Value(n), nil
+	case datastore.TypeBytes:
+		raw, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return datastore.Value{}, err
+		}
+		return datastore.BytesValue(raw), nil
+	default:
+		return datastore.StringValue(s), nil
+	}
+}
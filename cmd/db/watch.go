@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// watchPing is how often an idle event stream gets a comment line, so that
+// proxies do not close it.
+const watchPing = 15 * time.Second
+
+// watchHandler serves GET /db/_watch?prefix=&after= as Server-Sent Events.
+// Every event carries its sequence number as the id, and a client that
+// reconnects with Last-Event-ID gets the events it missed. Without a position
+// the stream starts with the next change. 410 Gone means the missed events
+// are no longer kept and the client has to read the keys again.
+func watchHandler(db *datastore.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		after := db.LastSeq()
+		position := r.Header.Get("Last-Event-ID")
+		if position == "" {
+			position = r.URL.Query().Get("after")
+		}
+		if position != "" {
+			n, err := strconv.ParseUint(position, 10, 64)
+			if err != nil {
+				http.Error(w, "bad position", http.StatusBadRequest)
+				return
+			}
+			after = n
+		}
+
+		watcher, err := db.Watch(r.URL.Query().Get("prefix"), after)
+		if err == datastore.ErrSeqUnavailable {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		} else if err != nil {
+			http.Error(w, "watch error", http.StatusInternalServerError)
+			return
+		}
+		defer watcher.Close()
+
+		// The stream outlives the write timeout of the server.
+		rc := http.NewResponseController(w)
+		_ = rc.SetWriteDeadline(time.Time{})
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		rc.Flush()
+
+		for {
+			ctx, cancel := context.WithTimeout(r.Context(), watchPing)
+			e, err := watcher.Next(ctx)
+			cancel()
+			switch {
+			case err == context.DeadlineExceeded:
+				fmt.Fprint(w, ": ping\n\n")
+			case err == datastore.ErrWatchLagged:
+				// The client reconnects and resumes from its last event.
+				fmt.Fprint(w, "event: lagged\ndata: {}\n\n")
+				rc.Flush()
+				return
+			case err != nil:
+				return
+			default:
+				if err := writeEvent(w, e); err != nil {
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+type watchEvent struct {
+	Key string `json:"key"`
+	*valueBody
+}
+
+func writeEvent(w http.ResponseWriter, e datastore.Event) error {
+	data := watchEvent{Key: e.Key}
+	kind := "delete"
+	if !e.Deleted {
+		body, err := encodeValue(e.Value)
+		if err != nil {
+			return err
+		}
+		data.valueBody = &body
+		kind = "put"
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, kind, payload)
+	return err
+}
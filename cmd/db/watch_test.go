@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readEvent returns the lines of the next event of a stream.
+func readEvent(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestWatchHandler(t *testing.T) {
+	db := openTestDB(t)
+	srv := httptest.NewServer(watchHandler(db))
+	t.Cleanup(srv.Close)
+	require.NoError(t, db.Put("other", "x"))
+
+	resp, err := http.Get(srv.URL + "?prefix=user/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	require.NoError(t, db.Put("user/1", "alice"))
+	require.NoError(t, db.Delete("user/1"))
+
+	events := bufio.NewReader(resp.Body)
+	assert.Equal(t, []string{"id: 2", "event: put", `data: {"key":"user/1","type":"string","value":"alice"}`}, readEvent(t, events))
+	assert.Equal(t, []string{"id: 3", "event: delete", `data: {"key":"user/1"}`}, readEvent(t, events))
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "2")
+	resumed, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resumed.Body.Close()
+	assert.Equal(t, []string{"id: 3", "event: delete", `data: {"key":"user/1"}`}, readEvent(t, bufio.NewReader(resumed.Body)))
+
+	gone, err := http.Get(srv.URL + "?after=100")
+	require.NoError(t, err)
+	gone.Body.Close()
+	assert.Equal(t, http.StatusGone, gone.StatusCode)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRemote(t *testing.T) {
+	db, err := datastore.Open(t.TempDir())
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Put("key", "value"))
+	var archive bytes.Buffer
+	require.NoError(t, db.Backup(&archive))
+
+	body := archive.Bytes()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	require.NoError(t, backupRemote(server.URL, &out))
+	assert.Equal(t, archive.Bytes(), out.Bytes())
+
+	// The server failed after the first entry and the status was already
+	// sent.
+	body = archive.Bytes()[:1024]
+	out.Reset()
+	assert.ErrorIs(t, backupRemote(server.URL, &out), datastore.ErrIncompleteBackup)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+type dumpRecord struct {
+	Segment   string     `json:"segment"`
+	Offset    int64      `json:"offset"`
+	Size      int64      `json:"size"`
+	Key       string     `json:"key"`
+	Type      string     `json:"type,omitempty"`
+	Value     any        `json:"value,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// runDump prints every record of the segments with its offset.
+func runDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	f := addInspectFlags(fs)
+	fs.Parse(args)
+
+	paths, err := f.segments(fs)
+	if err != nil {
+		return err
+	}
+	out := stdout(*f.json, "SEGMENT", "OFFSET", "SIZE", "KEY", "TYPE", "VALUE", "EXPIRES")
+	damaged := false
+	for _, path := range paths {
+		_, err := readSegment(path, func(pos datastore.Position, key string, value datastore.Value, deleted bool, expires time.Time) error {
+			rec := dumpRecord{Segment: filepath.Base(path), Offset: pos.Offset, Size: pos.Size, Key: key, Deleted: deleted}
+			kind, shown := "deleted", "-"
+			if !deleted {
+				kind, shown = value.Type.String(), displayValue(value)
+				rec.Type, rec.Value = kind, jsonValue(value)
+			}
+			if !expires.IsZero() {
+				rec.ExpiresAt = &expires
+			}
+			return out.row(rec, rec.Segment, pos.Offset, pos.Size, key, kind, shown, formatTime(expires))
+		})
+		var tail *tailError
+		if errors.As(err, &tail) {
+			// Records of the other segments are still worth seeing.
+			out.flush()
+			fmt.Fprintln(os.Stderr, tail)
+			damaged = true
+			continue
+		}
+		if err != nil {
+			out.flush()
+			return err
+		}
+	}
+	if err := out.flush(); err != nil {
+		return err
+	}
+	if damaged {
+		return errDamaged
+	}
+	return nil
+}
+
+// tailError reports a torn or corrupted record that ends the valid data of a
+// segment.
+type tailError struct {
+	path   string
+	offset int64
+	err    error
+}
+
+func (e *tailError) Error() string {
+	return fmt.Sprintf("%s: damaged at offset %d: %s", e.path, e.offset, e.err)
+}
+
+// readSegment calls fn for every valid record of the file and returns the
+// offset right after the last one. A damaged tail is reported as *tailError.
+func readSegment(path string, fn func(pos datastore.Position, key string, value datastore.Value, deleted bool, expires time.Time) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	r, err := datastore.NewSegmentReader(file)
+	if err != nil {
+		return 0, err
+	}
+	for pos, pair := range r.Records() {
+		if err := fn(pos, pair.Key(), pair.Value(), pair.Deleted(), pair.ExpiresAt()); err != nil {
+			return 0, err
+		}
+	}
+	switch {
+	case r.Damaged():
+		return r.End(), &tailError{path, r.End(), r.Err()}
+	case r.Err() != nil:
+		return 0, r.Err()
+	}
+	return r.End(), nil
+}
+
+type verifyResult struct {
+	Segment string `json:"segment"`
+	Version byte   `json:"version"`
+	Size    int64  `json:"size"`
+	Records int    `json:"records"`
+	// End is the offset right after the last valid record.
+	End   int64  `json:"end"`
+	Error string `json:"error,omitempty"`
+}
+
+var errDamaged = errors.New("damaged segments found")
+
+// runVerify reads every record and checks its checksum. It fails if any
+// segment has a torn or corrupted tail.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	f := addInspectFlags(fs)
+	fs.Parse(args)
+
+	paths, err := f.segments(fs)
+	if err != nil {
+		return err
+	}
+	out := stdout(*f.json, "SEGMENT", "VERSION", "SIZE", "RECORDS", "END", "STATUS")
+	damaged := false
+	for _, path := range paths {
+		res, err := verifySegment(path)
+		if err != nil {
+			out.flush()
+			return err
+		}
+		status := "ok"
+		if res.Error != "" {
+			status = res.Error
+			damaged = true
+		}
+		if err := out.row(res, res.Segment, res.Version, res.Size, res.Records, res.End, status); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil {
+		return err
+	}
+	if damaged {
+		return errDamaged
+	}
+	return nil
+}
+
+func verifySegment(path string) (verifyResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return verifyResult{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return verifyResult{}, err
+	}
+
+	r, err := datastore.NewSegmentReader(file)
+	if err != nil {
+		return verifyResult{}, err
+	}
+	res := verifyResult{Segment: filepath.Base(path), Version: r.Version(), Size: info.Size()}
+	for range r.Records() {
+		res.Records++
+	}
+	res.End = r.End()
+	if r.Err() != nil {
+		if !r.Damaged() {
+			return verifyResult{}, r.Err()
+		}
+		res.Error = r.Err().Error()
+	}
+	return res, nil
+}
+
+type segmentStats struct {
+	Segment  string `json:"segment"`
+	Size     int64  `json:"size"`
+	Records  int    `json:"records"`
+	LiveKeys int    `json:"live_keys"`
+	Live     int64  `json:"live_bytes"`
+	// Garbage counts overwritten, deleted and expired records together with
+	// a damaged tail.
+	Garbage int64 `json:"garbage_bytes"`
+	// Saved is how many bytes compression saved in the segment.
+	Saved int64 `json:"saved_bytes"`
+}
+
+// runStats replays the segments of a data directory in the same order as
+// Open does and shows how many bytes of each one are still live.
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	dir := fs.String("dir", "./data", "data directory")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	fs.Parse(args)
+
+	paths, err := datastore.SegmentPaths(*dir)
+	if err != nil {
+		return err
+	}
+
+	type latest struct {
+		seg     int
+		size    int64
+		expires time.Time
+	}
+	stats := make([]segmentStats, len(paths))
+	index := make(map[string]latest)
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		stats[i] = segmentStats{Segment: filepath.Base(path), Size: info.Size()}
+
+		var records int64
+		end, err := readSegment(path, func(pos datastore.Position, key string, _ datastore.Value, deleted bool, expires time.Time) error {
+			stats[i].Records++
+			stats[i].Saved += pos.Saved
+			records += pos.Size
+			if old, ok := index[key]; ok {
+				stats[old.seg].LiveKeys--
+				stats[old.seg].Live -= old.size
+				delete(index, key)
+			}
+			if !deleted {
+				index[key] = latest{i, pos.Size, expires}
+				stats[i].LiveKeys++
+				stats[i].Live += pos.Size
+			}
+			return nil
+		})
+		var tail *tailError
+		if err != nil && !errors.As(err, &tail) {
+			return err
+		}
+		// What is not live yet is garbage, the live bytes are taken out below.
+		stats[i].Garbage = records + info.Size() - end
+	}
+
+	now := time.Now()
+	for _, rec := range index {
+		if !rec.expires.IsZero() && !now.Before(rec.expires) {
+			stats[rec.seg].LiveKeys--
+			stats[rec.seg].Live -= rec.size
+		}
+	}
+
+	out := stdout(*asJSON, "SEGMENT", "SIZE", "RECORDS", "LIVE KEYS", "LIVE", "GARBAGE", "GARBAGE %", "SAVED")
+	for _, s := range stats {
+		s.Garbage -= s.Live
+		ratio := 0.0
+		if s.Size > 0 {
+			ratio = 100 * float64(s.Garbage) / float64(s.Size)
+		}
+		if err := out.row(s, s.Segment, s.Size, s.Records, s.LiveKeys, s.Live, s.Garbage, fmt.Sprintf("%.1f", ratio), s.Saved); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
+
+type repairResult struct {
+	Segment   string `json:"segment"`
+	Size      int64  `json:"size"`
+	End       int64  `json:"end"`
+	Truncated int64  `json:"truncated"`
+	Error     string `json:"error,omitempty"`
+}
+
+// runRepair truncates segments after their last valid record. The db must not
+// be running while the files are repaired.
+func runRepair(args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	f := addInspectFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "only show what would be truncated")
+	fs.Parse(args)
+
+	paths, err := f.segments(fs)
+	if err != nil {
+		return err
+	}
+	out := stdout(*f.json, "SEGMENT", "SIZE", "END", "TRUNCATED", "REASON")
+	for _, path := range paths {
+		res, err := repairSegment(path, *dryRun)
+		if err != nil {
+			out.flush()
+			return err
+		}
+		reason := "-"
+		if res.Error != "" {
+			reason = res.Error
+		}
+		if err := out.row(res, res.Segment, res.Size, res.End, res.Truncated, reason); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
+
+func repairSegment(path string, dryRun bool) (repairResult, error) {
+	res, err := verifySegment(path)
+	if err != nil || res.Error == "" {
+		return repairResult{Segment: res.Segment, Size: res.Size, End: res.End}, err
+	}
+
+	if !dryRun {
+		file, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return repairResult{}, err
+		}
+		defer file.Close()
+		if err := file.Truncate(res.End); err != nil {
+			return repairResult{}, err
+		}
+		if err := file.Sync(); err != nil {
+			return repairResult{}, err
+		}
+	}
+	return repairResult{res.Segment, res.Size, res.End, res.Size - res.End, res.Error}, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairSegment(t *testing.T) {
+	dir := t.TempDir()
+	db, err := datastore.Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, db.Put("key", "value"))
+	require.NoError(t, db.Close())
+
+	paths, err := datastore.SegmentPaths(dir)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	f, err := os.OpenFile(paths[0], os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("torn"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	res, err := verifySegment(paths[0])
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Records)
+	assert.NotEmpty(t, res.Error)
+
+	repaired, err := repairSegment(paths[0], false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), repaired.Truncated)
+
+	res, err = verifySegment(paths[0])
+	require.NoError(t, err)
+	assert.Empty(t, res.Error)
+	assert.Equal(t, res.End, res.Size)
+}
@@ -0,0 +1,60 @@
+// Command dbctl administers the datastore used by cmd/db.
+//
+// Usage:
+//
+//	dbctl <command> [flags]
+//
+// Run "dbctl <command> -h" for the flags of a command.
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"backup", "write a backup archive of a running db or a data directory", runBackup},
+	{"restore", "unpack a backup archive into an empty data directory", runRestore},
+	{"dump", "print the records of segment files with their offsets", runDump},
+	{"verify", "check the checksums of all records", runVerify},
+	{"stats", "show live and garbage bytes per segment", runStats},
+	{"repair", "truncate torn or corrupted segment tails", runRepair},
+	{"rebalance", "move keys between sharded nodes after a node is added", runRebalance},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	i := slices.IndexFunc(commands, func(c command) bool {
+		return c.name == os.Args[1]
+	})
+	if i < 0 {
+		fmt.Fprintf(os.Stderr, "dbctl: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := commands[i].run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "dbctl %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	var b strings.Builder
+	b.WriteString("Usage: dbctl <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "  %-10s %s\n", c.name, c.usage)
+	}
+	fmt.Fprint(os.Stderr, b.String())
+}
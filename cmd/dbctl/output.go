@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// inspectFlags are shared by the commands that work on segment files.
+type inspectFlags struct {
+	dir  *string
+	json *bool
+}
+
+func addInspectFlags(fs *flag.FlagSet) inspectFlags {
+	return inspectFlags{
+		dir:  fs.String("dir", "./data", "data directory, used when no files are given"),
+		json: fs.Bool("json", false, "print JSON instead of a table"),
+	}
+}
+
+// segments returns the files given as arguments or all segments of the data
+// directory in replay order.
+func (f inspectFlags) segments(fs *flag.FlagSet) ([]string, error) {
+	if fs.NArg() > 0 {
+		return fs.Args(), nil
+	}
+	paths, err := datastore.SegmentPaths(*f.dir)
+	if err == nil && len(paths) == 0 {
+		err = fmt.Errorf("no segments in %s", *f.dir)
+	}
+	return paths, err
+}
+
+// output prints rows either as JSON, one object per line, or as a table with
+// the given columns.
+type output struct {
+	json bool
+	enc  *json.Encoder
+	tw   *tabwriter.Writer
+}
+
+func newOutput(w io.Writer, asJSON bool, columns ...string) *output {
+	if asJSON {
+		return &output{json: true, enc: json.NewEncoder(w)}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	return &output{tw: tw}
+}
+
+func (o *output) row(v any, cells ...any) error {
+	if o.json {
+		return o.enc.Encode(v)
+	}
+	text := make([]string, len(cells))
+	for i, c := range cells {
+		text[i] = fmt.Sprint(c)
+	}
+	_, err := fmt.Fprintln(o.tw, strings.Join(text, "\t"))
+	return err
+}
+
+func (o *output) flush() error {
+	if o.json {
+		return nil
+	}
+	return o.tw.Flush()
+}
+
+func stdout(asJSON bool, columns ...string) *output {
+	return newOutput(os.Stdout, asJSON, columns...)
+}
+
+// displayValue renders a value for the table output.
+func displayValue(v datastore.Value) string {
+	const limit = 40
+	var s string
+	switch v.Type {
+	case datastore.TypeInt64:
+		n, _ := v.AsInt64()
+		return strconv.FormatInt(n, 10)
+	case datastore.TypeBytes:
+		b, _ := v.AsBytes()
+		s = fmt.Sprintf("%x", b)
+	default:
+		s, _ = v.AsString()
+		s = strconv.Quote(s)
+	}
+	if len(s) > limit {
+		s = s[:limit] + "..."
+	}
+	return s
+}
+
+// jsonValue converts a value to what encoding/json prints for it: bytes end
+// up base64 encoded like in the HTTP API.
+func jsonValue(v datastore.Value) any {
+	switch v.Type {
+	case datastore.TypeInt64:
+		n, _ := v.AsInt64()
+		return n
+	case datastore.TypeBytes:
+		b, _ := v.AsBytes()
+		return b
+	}
+	s, _ := v.AsString()
+	return s
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/roman-mazur/architecture-practice-4-template/shard"
+)
+
+type rebalanceResult struct {
+	Node  string `json:"node"`
+	Keys  int    `json:"keys"`
+	Moved int    `json:"moved"`
+}
+
+// runRebalance copies the keys whose owner differs between the old and the
+// new node list, or deletes them from the old owners with -delete. See the
+// shard package for the whole procedure.
+func runRebalance(args []string) error {
+	fs := flag.NewFlagSet("rebalance", flag.ExitOnError)
+	oldNodes := fs.String("old", "", "comma separated host:port of the current nodes")
+	newNodes := fs.String("new", "", "comma separated host:port of the nodes after the change")
+	remove := fs.Bool("delete", false, "delete moved keys from their old owners instead of copying them")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	fs.Parse(args)
+
+	if *oldNodes == "" || *newNodes == "" {
+		return errors.New("-old and -new are required")
+	}
+	ring := shard.NewRing(strings.Split(*newNodes, ","), 0)
+
+	out := stdout(*asJSON, "NODE", "KEYS", "MOVED")
+	for _, node := range strings.Split(*oldNodes, ",") {
+		res, err := rebalanceNode(node, ring, *remove)
+		if err != nil {
+			out.flush()
+			return fmt.Errorf("%s: %w", node, err)
+		}
+		if err := out.row(res, res.Node, res.Keys, res.Moved); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
+
+type listedItem struct {
+	Key        string          `json:"key"`
+	Type       string          `json:"type,omitempty"`
+	Value      json.RawMessage `json:"value"`
+	TTLSeconds int64           `json:"ttl_seconds,omitempty"`
+}
+
+func rebalanceNode(node string, ring *shard.Ring, remove bool) (rebalanceResult, error) {
+	res := rebalanceResult{Node: node}
+	cursor := ""
+	for {
+		var page struct {
+			Items      []listedItem `json:"items"`
+			NextCursor string       `json:"next_cursor"`
+		}
+		query := url.Values{"limit": {"1000"}, "cursor": {cursor}}
+		if err := nodeRequest(http.MethodGet, "http://"+node+"/db?"+query.Encode(), nil, &page); err != nil {
+			return res, err
+		}
+
+		for _, item := range page.Items {
+			res.Keys++
+			owner := ring.Node(item.Key)
+			if owner == node {
+				continue
+			}
+			var err error
+			if remove {
+				err = nodeRequest(http.MethodDelete, keyURL(node, item.Key), nil, nil)
+			} else {
+				// The new owner gets the key with the time it has left.
+				body, _ := json.Marshal(listedItem{Type: item.Type, Value: item.Value, TTLSeconds: item.TTLSeconds})
+				err = nodeRequest(http.MethodPost, keyURL(owner, item.Key), body, nil)
+			}
+			if err != nil {
+				return res, err
+			}
+			res.Moved++
+		}
+
+		if page.NextCursor == "" {
+			return res, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func keyURL(node, key string) string {
+	return "http://" + node + "/db/" + url.PathEscape(key)
+}
+
+// nodeRequest talks to one node directly: the forwarded header stops it from
+// routing the request by its own node list.
+func nodeRequest(method, target string, body []byte, reply any) error {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(shard.ForwardedHeader, "dbctl")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// A key deleted since it was listed needs no cleanup.
+	if method == http.MethodDelete && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: unexpected status %s", method, target, resp.Status)
+	}
+	if reply == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
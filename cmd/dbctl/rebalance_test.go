@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/shard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNode stores string values and serves the part of the db API that
+// rebalance uses, in a single page.
+type fakeNode struct {
+	mu   sync.Mutex
+	data map[string]string
+	ttls map[string]int64
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if r.URL.Path == "/db" {
+		var items []listedItem
+		for key, value := range n.data {
+			raw, _ := json.Marshal(value)
+			items = append(items, listedItem{Key: key, Value: raw, TTLSeconds: n.ttls[key]})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"items": items})
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/db/")
+	switch r.Method {
+	case http.MethodPost:
+		var body struct {
+			Value      string
+			TTLSeconds int64 `json:"ttl_seconds"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		n.data[key] = body.Value
+		if body.TTLSeconds > 0 {
+			n.ttls[key] = body.TTLSeconds
+		}
+	case http.MethodDelete:
+		delete(n.data, key)
+	}
+}
+
+func TestRebalanceNode(t *testing.T) {
+	nodes := []*fakeNode{
+		{data: map[string]string{}, ttls: map[string]int64{}},
+		{data: map[string]string{}, ttls: map[string]int64{}},
+	}
+	var addrs []string
+	for _, n := range nodes {
+		srv := httptest.NewServer(n)
+		t.Cleanup(srv.Close)
+		addrs = append(addrs, strings.TrimPrefix(srv.URL, "http://"))
+	}
+	for i := 0; i < 100; i++ {
+		nodes[0].data[fmt.Sprintf("key%d", i)] = "v"
+		if i%2 == 0 {
+			nodes[0].ttls[fmt.Sprintf("key%d", i)] = int64(i + 1)
+		}
+	}
+
+	ring := shard.NewRing(addrs, 0)
+	res, err := rebalanceNode(addrs[0], ring, false)
+	require.NoError(t, err)
+	assert.Equal(t, 100, res.Keys)
+	assert.Len(t, nodes[1].data, res.Moved)
+	assert.Len(t, nodes[0].data, 100)
+	for key := range nodes[1].data {
+		assert.Equal(t, nodes[0].ttls[key], nodes[1].ttls[key], "ttl of %s", key)
+	}
+
+	_, err = rebalanceNode(addrs[0], ring, true)
+	require.NoError(t, err)
+	assert.Len(t, nodes[0].data, 100-res.Moved)
+	for key := range nodes[1].data {
+		assert.Equal(t, addrs[1], ring.Node(key))
+		_, left := nodes[0].data[key]
+		assert.False(t, left, key)
+	}
+}
@@ -7,18 +7,49 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
+// dbHosts lists the db leader first and then its followers, taken from
+// DB_HOSTS. Reads fall back to the followers while the leader is down.
+func dbHosts() []string {
+	hosts := os.Getenv("DB_HOSTS")
+	if hosts == "" {
+		return []string{"db:8082"}
+	}
+	return strings.Split(hosts, ",")
+}
+
+// getFromDB asks every db host in turn until one of them answers.
+func getFromDB(hosts []string, key string) (*http.Response, error) {
+	var (
+		resp *http.Response
+		err  error
+	)
+	for _, host := range hosts {
+		resp, err = http.DefaultClient.Get(fmt.Sprintf("http://%s/db/%s", host, key))
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("db %s replied %s", host, resp.Status)
+		}
+	}
+	return nil, err
+}
+
 func main() {
 	team := os.Getenv("TEAM_NAME")
 	if team == "" {
 		log.Fatal("TEAM_NAME must be set")
 	}
+	hosts := dbHosts()
 	today := time.Now().Format("2006-01-02")
 	payload, _ := json.Marshal(map[string]string{"value": today})
 	resp, err := http.DefaultClient.Post(
-		fmt.Sprintf("http://db:8082/db/%s", team),
+		fmt.Sprintf("http://%s/db/%s", hosts[0], team),
 		"application/json",
 		bytes.NewReader(payload),
 	)
@@ -44,8 +75,7 @@ func main() {
 			return
 		}
 
-		dbURL := fmt.Sprintf("http://db:8082/db/%s", key)
-		resp, err := http.DefaultClient.Get(dbURL)
+		resp, err := getFromDB(hosts, key)
 		if err != nil {
 			http.Error(rw, "db error", http.StatusServiceUnavailable)
 			return
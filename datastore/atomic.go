@@ -0,0 +1,83 @@
+package datastore
+
+import "errors"
+
+var (
+	ErrConflict = errors.New("value does not match")
+	ErrOverflow = errors.New("increment overflows int64")
+)
+
+// addInt64 returns a+b, or ErrOverflow if the sum does not fit in an int64.
+func addInt64(a, b int64) (int64, error) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, ErrOverflow
+	}
+	return sum, nil
+}
+
+// keepExpiry gives pair the expiry of the record that was read, so that
+// rewriting a key in place does not clear its TTL.
+func (r readResult) keepExpiry(pair kvPair) kvPair {
+	pair.expiresAt = r.expiresAt
+	return pair
+}
+
+// Increment adds delta to the int64 value of the key and returns the result.
+// An absent key counts as zero. A result that does not fit in an int64 fails
+// with ErrOverflow and leaves the value as it was. The read and the write
+// happen in the writer goroutine, so concurrent increments never lose updates.
+// Like the other operations in this file it keeps the TTL of the key.
+func (db *Database) Increment(key string, delta int64) (int64, error) {
+	var result int64
+	err := db.write(writeRequest{prepare: func() ([]kvPair, error) {
+		current := db.read(key)
+		err := current.err
+		if err == nil {
+			result, err = current.value.AsInt64()
+		} else if err == ErrKeyMissing {
+			result, err = 0, nil
+		}
+		if err == nil {
+			result, err = addInt64(result, delta)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []kvPair{current.keepExpiry(Int64Value(result).pair(key))}, nil
+	}})
+	return result, err
+}
+
+// CompareAndSwap writes next only if the key currently holds old, type
+// included. It returns ErrConflict if the value differs and ErrKeyMissing if
+// there is no value at all.
+func (db *Database) CompareAndSwap(key string, old, next Value) error {
+	return db.write(writeRequest{prepare: func() ([]kvPair, error) {
+		current := db.read(key)
+		if current.err != nil {
+			return nil, current.err
+		}
+		if current.value != old {
+			return nil, ErrConflict
+		}
+		return []kvPair{current.keepExpiry(next.pair(key))}, nil
+	}})
+}
+
+// CompareVersionAndSwap writes next only if the latest write to the key is
+// the one with the given version, as returned by GetWithVersion. It returns
+// ErrConflict if the key was written since and ErrKeyMissing if there is no
+// value at all.
+func (db *Database) CompareVersionAndSwap(key string, version uint64, next Value) error {
+	return db.write(writeRequest{prepare: func() ([]kvPair, error) {
+		current := db.read(key)
+		if current.err != nil {
+			return nil, current.err
+		}
+		if current.seq != version {
+			return nil, ErrConflict
+		}
+		return []kvPair{current.keepExpiry(next.pair(key))}, nil
+	}})
+}
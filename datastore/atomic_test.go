@@ -0,0 +1,142 @@
+package datastore
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestIncrement(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	var wg sync.WaitGroup
+	for w := 0; w < 10; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := db.Increment("counter", 2); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, err := db.GetInt64("counter"); err != nil || n != 1000 {
+		t.Errorf("GetInt64(counter) = %d, %v, wanted 1000", n, err)
+	}
+	if n, err := db.Increment("counter", -1000); err != nil || n != 0 {
+		t.Errorf("Increment(counter, -1000) = %d, %v", n, err)
+	}
+
+	if err := db.Put("text", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Increment("text", 1); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType incrementing a string, got %v", err)
+	}
+
+	if err := db.PutValue("max", Int64Value(math.MaxInt64)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Increment("max", 1); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected ErrOverflow, got %v", err)
+	}
+	if _, err := db.Increment("counter", math.MinInt64); err != nil {
+		t.Errorf("Increment(counter, MinInt64) = %v", err)
+	}
+	if _, err := db.Increment("counter", -1); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected ErrOverflow, got %v", err)
+	}
+	if n, err := db.GetInt64("max"); err != nil || n != math.MaxInt64 {
+		t.Errorf("GetInt64(max) = %d, %v after the overflow", n, err)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.CompareAndSwap("key", StringValue("a"), StringValue("b")); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Expected ErrKeyMissing, got %v", err)
+	}
+	if err := db.Put("key", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CompareAndSwap("key", StringValue("x"), StringValue("b")); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if err := db.CompareAndSwap("key", StringValue("a"), StringValue("b")); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if value, err := db.Get("key"); err != nil || value != "b" {
+		t.Errorf("Get(key) = %q, %v", value, err)
+	}
+
+	// Only one of the concurrent swaps from the same value can win.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wins := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if db.CompareAndSwap("key", StringValue("b"), StringValue("c")) == nil {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Errorf("Expected exactly one successful swap, got %d", wins)
+	}
+}
+
+func TestCompareVersionAndSwap(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.CompareVersionAndSwap("key", 1, StringValue("b")); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Expected ErrKeyMissing, got %v", err)
+	}
+	if err := db.Put("key", "a"); err != nil {
+		t.Fatal(err)
+	}
+	_, version, err := db.GetWithVersion("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CompareVersionAndSwap("key", version+1, StringValue("b")); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if err := db.CompareVersionAndSwap("key", version, StringValue("b")); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	// The swap itself is a new version.
+	if err := db.CompareVersionAndSwap("key", version, StringValue("c")); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict for a stale version, got %v", err)
+	}
+	if value, err := db.Get("key"); err != nil || value != "b" {
+		t.Errorf("Get(key) = %q, %v", value, err)
+	}
+}
@@ -0,0 +1,287 @@
+package datastore
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An archive made by Backup or BackupDir ends with an entry named
+// backupEndName that lists the segment entries before it, one line each:
+//
+//	id size crc32
+//
+// An archive cut short on the way, such as a backup response that broke off
+// after its status was sent, has no such entry or one that does not match,
+// and Restore and VerifyBackup reject it.
+const backupEndName = "BACKUP-END"
+
+var ErrIncompleteBackup = errors.New("backup archive is incomplete")
+
+type archivedSegment struct {
+	id   int
+	size int64
+	sum  uint32
+}
+
+type archiveWriter struct {
+	tw       *tar.Writer
+	segments []archivedSegment
+}
+
+func newArchiveWriter(w io.Writer) *archiveWriter {
+	return &archiveWriter{tw: tar.NewWriter(w)}
+}
+
+// add archives the first size bytes of a segment file.
+func (a *archiveWriter) add(id int, mode os.FileMode, r io.ReaderAt, size int64) error {
+	header := &tar.Header{
+		Name:    baseFilename + strconv.Itoa(id),
+		Mode:    int64(mode.Perm()),
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	sum := crc32.New(crcTable)
+	if _, err := io.Copy(io.MultiWriter(a.tw, sum), io.NewSectionReader(r, 0, size)); err != nil {
+		return err
+	}
+	a.segments = append(a.segments, archivedSegment{id, size, sum.Sum32()})
+	return nil
+}
+
+// finish writes the end entry and closes the archive.
+func (a *archiveWriter) finish() error {
+	var list bytes.Buffer
+	for _, s := range a.segments {
+		fmt.Fprintf(&list, "%d %d %08x\n", s.id, s.size, s.sum)
+	}
+	header := &tar.Header{
+		Name:    backupEndName,
+		Mode:    0o600,
+		Size:    int64(list.Len()),
+		ModTime: time.Now(),
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := a.tw.Write(list.Bytes()); err != nil {
+		return err
+	}
+	return a.tw.Close()
+}
+
+// Backup writes a tar archive of the segment files to w while the database
+// keeps serving requests. The archive holds the state at the moment Backup
+// was called: sealed segments are copied whole and the active one up to the
+// offset it had then, all in replay order. Hint files are left out, Open
+// rebuilds them.
+func (db *Database) Backup(w io.Writer) error {
+	var (
+		snap  *Snapshot
+		sizes []int64
+	)
+	// Nothing is written while the writer goroutine runs prepare, so the
+	// sizes match the snapshot exactly.
+	err := db.write(writeRequest{prepare: func() ([]kvPair, error) {
+		snap = db.Snapshot()
+		for _, seg := range snap.segments {
+			info, err := seg.file.Stat()
+			if err != nil {
+				return nil, err
+			}
+			sizes = append(sizes, info.Size())
+		}
+		return nil, nil
+	}})
+	if snap != nil {
+		defer snap.Release()
+	}
+	if err != nil {
+		return err
+	}
+
+	aw := newArchiveWriter(w)
+	for i, seg := range snap.segments {
+		if err := aw.add(seg.id, db.opts.fileMode, seg.file, sizes[i]); err != nil {
+			return err
+		}
+	}
+	return aw.finish()
+}
+
+// BackupDir writes the same archive as Backup for a directory that no
+// database has open. Nothing in dir is changed: a damaged tail is copied as
+// it is and Open deals with it after Restore.
+func BackupDir(dir string, w io.Writer) error {
+	m, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	aw := newArchiveWriter(w)
+	for _, id := range m.ids {
+		f, err := os.Open(filepath.Join(dir, baseFilename+strconv.Itoa(id)))
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err == nil {
+			err = aw.add(id, info.Mode(), f, info.Size())
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return aw.finish()
+}
+
+// VerifyBackup reads an archive to its end and checks that it is complete,
+// without unpacking it. It returns ErrIncompleteBackup if the archive was
+// cut short.
+func VerifyBackup(r io.Reader) error {
+	err := readArchive(r, func(header *tar.Header, id int, content io.Reader) error {
+		_, err := io.Copy(io.Discard, content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// Whatever follows the end of the archive is read too, so that a copy
+	// made through r is whole.
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+// Restore unpacks an archive made by Backup into dir, which must not hold
+// any segments yet, and writes a manifest that lists the restored ones in the
+// order of the archive. An incomplete archive fails with ErrIncompleteBackup
+// and leaves dir as it was. The database must not be open in dir while
+// Restore runs.
+func Restore(dir string, r io.Reader) error {
+	ids, err := segmentIDs(dir)
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		return errors.New("directory already holds a database")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	var (
+		written []string
+		m       manifest
+	)
+	err = readArchive(r, func(header *tar.Header, id int, content io.Reader) error {
+		path := filepath.Join(dir, header.Name)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(header.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		written = append(written, path)
+		m.ids = append(m.ids, id)
+		m.next = max(m.next, id+1)
+
+		_, err = io.Copy(f, content)
+		if err == nil {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+	if err == nil {
+		err = writeManifest(dir, m, 0o600)
+	}
+	if err != nil {
+		for _, path := range written {
+			_ = os.Remove(path)
+		}
+	}
+	return err
+}
+
+// readArchive calls segment for every segment entry of an archive and then
+// checks the entries against the end entry.
+func readArchive(r io.Reader, segment func(header *tar.Header, id int, content io.Reader) error) error {
+	err := readArchiveEntries(r, segment)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrIncompleteBackup
+	}
+	return err
+}
+
+func readArchiveEntries(r io.Reader, segment func(header *tar.Header, id int, content io.Reader) error) error {
+	tr := tar.NewReader(r)
+	var got []archivedSegment
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return ErrIncompleteBackup
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Name == backupEndName {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if want, err := decodeBackupEnd(data); err != nil || !slices.Equal(got, want) {
+				return ErrIncompleteBackup
+			}
+			if _, err := tr.Next(); err != io.EOF {
+				return fmt.Errorf("unexpected archive entry after %s", backupEndName)
+			}
+			return nil
+		}
+
+		// Only plain segment names are accepted, so an entry cannot point
+		// outside of dir.
+		id, err := strconv.Atoi(strings.TrimPrefix(header.Name, baseFilename))
+		if header.Typeflag != tar.TypeReg || err != nil || id < 0 || header.Name != baseFilename+strconv.Itoa(id) {
+			return fmt.Errorf("unexpected archive entry %q", header.Name)
+		}
+
+		sum := crc32.New(crcTable)
+		if err := segment(header, id, io.TeeReader(tr, sum)); err != nil {
+			return err
+		}
+		// The rest of an entry that segment did not read still counts.
+		if _, err := io.Copy(sum, tr); err != nil {
+			return err
+		}
+		got = append(got, archivedSegment{id, header.Size, sum.Sum32()})
+	}
+}
+
+func decodeBackupEnd(data []byte) ([]archivedSegment, error) {
+	var segments []archivedSegment
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var s archivedSegment
+		if _, err := fmt.Sscanf(line, "%d %d %x", &s.id, &s.size, &s.sum); err != nil {
+			return nil, err
+		}
+		segments = append(segments, s)
+	}
+	return segments, nil
+}
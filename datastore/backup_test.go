@@ -0,0 +1,211 @@
+package datastore
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	db := openSmall(t, t.TempDir(), CompactionConfig{})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 30; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("key0"); err != nil {
+		t.Fatal(err)
+	}
+	// The merged segment gets a higher id than the active one, the archive
+	// has to keep the replay order.
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key1", "after compaction"); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := db.Backup(&archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("later", value); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := Restore(dir, bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = restored.Close()
+	})
+
+	if stats := restored.Recovery(); stats.Discarded != 0 {
+		t.Errorf("Restored segments are damaged: %+v", stats)
+	}
+	if keys := restored.Keys(""); len(keys) != 29 {
+		t.Errorf("Expected 29 keys in the backup, got %d", len(keys))
+	}
+	if _, err := restored.Get("key0"); err != ErrKeyMissing {
+		t.Errorf("Expected key0 to stay deleted, got %v", err)
+	}
+	if _, err := restored.Get("later"); err != ErrKeyMissing {
+		t.Errorf("Expected a key written after the backup to be missing, got %v", err)
+	}
+	if got, err := restored.Get("key29"); err != nil || got != value {
+		t.Errorf("Get(key29) = %q, %v", got, err)
+	}
+	if got, err := restored.Get("key1"); err != nil || got != "after compaction" {
+		t.Errorf("Get(key1) = %q, %v", got, err)
+	}
+
+	if err := Restore(dir, bytes.NewReader(archive.Bytes())); err == nil {
+		t.Error("Expected Restore into a non-empty directory to fail")
+	}
+}
+
+func TestBackupDir(t *testing.T) {
+	src := t.TempDir()
+	db := openSmall(t, src, CompactionConfig{})
+	for i := 0; i < 30; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), strings.Repeat("v", 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A torn tail that Open would truncate.
+	paths, err := SegmentPaths(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := os.OpenFile(paths[len(paths)-1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := active.Write([]byte("torn")); err != nil {
+		t.Fatal(err)
+	}
+	active.Close()
+
+	before := dirContents(t, src)
+	var archive bytes.Buffer
+	if err := BackupDir(src, &archive); err != nil {
+		t.Fatal(err)
+	}
+	if after := dirContents(t, src); !reflect.DeepEqual(before, after) {
+		t.Error("BackupDir changed the directory it backs up")
+	}
+
+	dir := t.TempDir()
+	if err := Restore(dir, &archive); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = restored.Close()
+	})
+	if keys := restored.Keys(""); len(keys) != 30 {
+		t.Errorf("Expected 30 keys in the backup, got %d", len(keys))
+	}
+}
+
+// dirContents maps the names of the files in dir to their contents.
+func dirContents(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make(map[string]string)
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[e.Name()] = string(data)
+	}
+	return contents
+}
+
+func TestRestoreRejectsIncompleteArchive(t *testing.T) {
+	db := openSmall(t, t.TempDir(), CompactionConfig{})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	for i := 0; i < 30; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), strings.Repeat("v", 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var archive bytes.Buffer
+	if err := db.Backup(&archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyBackup(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("VerifyBackup() = %v for a complete archive", err)
+	}
+
+	// The stream broke off after the first segment. A cut between entries
+	// looks like the end of the archive to the tar reader.
+	first, err := tar.NewReader(bytes.NewReader(archive.Bytes())).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstEnd := 512 + (first.Size+511)/512*512
+	cuts := map[string][]byte{
+		"between entries": archive.Bytes()[:firstEnd],
+		"inside an entry": archive.Bytes()[:archive.Len()/2],
+	}
+	for name, cut := range cuts {
+		if err := VerifyBackup(bytes.NewReader(cut)); !errors.Is(err, ErrIncompleteBackup) {
+			t.Errorf("%s: VerifyBackup() = %v, wanted ErrIncompleteBackup", name, err)
+		}
+		dir := t.TempDir()
+		if err := Restore(dir, bytes.NewReader(cut)); !errors.Is(err, ErrIncompleteBackup) {
+			t.Errorf("%s: Restore() = %v, wanted ErrIncompleteBackup", name, err)
+		}
+		if contents := dirContents(t, dir); len(contents) != 0 {
+			t.Errorf("%s: Restore left %d files behind", name, len(contents))
+		}
+	}
+}
+
+func TestRestoreRejectsUnknownEntries(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	data := []byte("data")
+	if err := tw.WriteHeader(&tar.Header{Name: "../" + baseFilename + "1", Mode: 0o600, Size: int64(len(data))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(t.TempDir(), &archive); err == nil {
+		t.Error("Expected an entry outside of the directory to be rejected")
+	}
+}
@@ -0,0 +1,42 @@
+package datastore
+
+// Batch collects writes that are committed atomically: after a crash either
+// all of them are visible or none.
+type Batch struct {
+	commit func([]kvPair) error
+	pairs  []kvPair
+}
+
+func (db *Database) NewBatch() *Batch {
+	return &Batch{commit: func(pairs []kvPair) error {
+		return db.write(writeRequest{pairs: pairs})
+	}}
+}
+
+func (b *Batch) Put(key, value string) *Batch {
+	return b.PutValue(key, StringValue(value))
+}
+
+func (b *Batch) PutValue(key string, value Value) *Batch {
+	b.pairs = append(b.pairs, value.pair(key))
+	return b
+}
+
+// Delete removes the key when the batch is committed. Unlike
+// Database.Delete, deleting an absent key is not an error.
+func (b *Batch) Delete(key string) *Batch {
+	b.pairs = append(b.pairs, kvPair{key: key, deleted: true})
+	return b
+}
+
+func (b *Batch) Len() int {
+	return len(b.pairs)
+}
+
+// Commit appends all records of the batch in a single write.
+func (b *Batch) Commit() error {
+	if len(b.pairs) == 0 {
+		return nil
+	}
+	return b.commit(b.pairs)
+}
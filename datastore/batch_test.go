@@ -0,0 +1,96 @@
+package datastore
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	err = db.NewBatch().
+		Put("k2", "v2").
+		Put("k3", "v3").
+		Delete("k1").
+		Delete("absent").
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		t.Helper()
+		if _, err := db.Get("k1"); !errors.Is(err, ErrKeyMissing) {
+			t.Errorf("Expected k1 to be deleted, got %v", err)
+		}
+		for key, expected := range map[string]string{"k2": "v2", "k3": "v3"} {
+			if value, err := db.Get(key); err != nil || value != expected {
+				t.Errorf("Get(%q) = %q, %v, wanted %q", key, value, err, expected)
+			}
+		}
+	}
+	check()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
+
+func TestBatchTornOnCrash(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.NewBatch().Put("k1", "v2").Put("k2", "v2").Commit(); err != nil {
+		t.Fatal(err)
+	}
+	batchStart := mustPos(t, db, "k1")
+	last := mustPos(t, db, "k2")
+	path := last.seg.file.Name()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the batch after its first record: the whole batch must be rolled back.
+	if err := os.Truncate(path, last.offset+last.size-3); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := db.Get("k1"); err != nil || value != "v1" {
+		t.Errorf("Expected k1 to keep its value from before the batch, got %q, %v", value, err)
+	}
+	if _, err := db.Get("k2"); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Expected k2 to be missing, got %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != batchStart.offset {
+		t.Errorf("Expected the incomplete batch to be truncated")
+	}
+}
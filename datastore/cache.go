@@ -0,0 +1,150 @@
+package datastore
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// CacheStats describes the value cache of a database.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Entries and Size are the number of cached values and the bytes of
+	// keys and values they hold.
+	Entries int
+	Size    int64
+	// Capacity is the configured size limit, zero if the cache is disabled.
+	Capacity int64
+}
+
+// valueCache keeps recently read values in memory, evicting the least
+// recently used ones once their size exceeds the capacity. A nil cache holds
+// nothing. The database keeps it consistent with the index: entries are only
+// added by readers holding db.mu for reading and changed by writers holding
+// it for writing.
+type valueCache struct {
+	capacity int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+
+	hits, misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key   string
+	value Value
+	seq   uint64
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.value.data))
+}
+
+func newValueCache(capacity int64) *valueCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &valueCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *valueCache) get(key string) (Value, uint64, bool) {
+	if c == nil {
+		return Value{}, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return Value{}, 0, false
+	}
+	c.hits.Add(1)
+	c.order.MoveToFront(el)
+	e := el.Value.(*cacheEntry)
+	return e.value, e.seq, true
+}
+
+func (c *valueCache) add(key string, value Value, seq uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addLocked(&cacheEntry{key, value, seq})
+}
+
+func (c *valueCache) addLocked(e *cacheEntry) {
+	c.removeLocked(e.key)
+	if e.size() > c.capacity {
+		return
+	}
+	c.entries[e.key] = c.order.PushFront(e)
+	c.size += e.size()
+	for c.size > c.capacity {
+		c.removeLocked(c.order.Back().Value.(*cacheEntry).key)
+	}
+}
+
+// update applies a written record to the cache. Values that are not cached
+// yet are left to the next read, so bulk writes do not push out hot keys.
+func (c *valueCache) update(pair kvPair, now int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[pair.key]; !ok {
+		return
+	}
+	if pair.deleted || pair.expired(now) {
+		c.removeLocked(pair.key)
+		return
+	}
+	c.addLocked(&cacheEntry{pair.key, Value{pair.kind, pair.value}, pair.seq})
+}
+
+func (c *valueCache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+}
+
+func (c *valueCache) removeLocked(key string) {
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*cacheEntry).size()
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+func (c *valueCache) stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Entries:  len(c.entries),
+		Size:     c.size,
+		Capacity: c.capacity,
+	}
+}
+
+// CacheStats reports the hits and misses of the value cache set up with
+// WithCacheSize.
+func (db *Database) CacheStats() CacheStats {
+	return db.cache.stats()
+}
@@ -0,0 +1,74 @@
+package datastore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValueCacheEviction(t *testing.T) {
+	c := newValueCache(10)
+	c.add("a", StringValue("1234"), 1)
+	c.add("b", StringValue("1234"), 2)
+	if _, _, ok := c.get("a"); !ok {
+		t.Fatal("Expected a to be cached")
+	}
+	// b is the least recently used one now.
+	c.add("c", StringValue("1234"), 3)
+	if _, _, ok := c.get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	if value, seq, ok := c.get("a"); !ok || value.data != "1234" || seq != 1 {
+		t.Errorf("get(a) = %v, %d, %t", value, seq, ok)
+	}
+	c.add("huge", StringValue("0123456789"), 4)
+	if _, _, ok := c.get("huge"); ok {
+		t.Error("Expected a value larger than the cache to be skipped")
+	}
+
+	stats := c.stats()
+	if stats.Entries != 2 || stats.Size != 10 || stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestDbCache(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithCacheSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.Put("key", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if value, err := db.Get("key"); err != nil || value != "v1" {
+			t.Fatalf("Get(key) = %q, %v", value, err)
+		}
+	}
+	if stats := db.CacheStats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	if err := db.Put("key", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("key"); err != nil || value != "v2" {
+		t.Errorf("Get(key) = %q, %v after an update", value, err)
+	}
+	if stats := db.CacheStats(); stats.Hits != 3 {
+		t.Errorf("Expected the update to be written through the cache, got %+v", stats)
+	}
+
+	if err := db.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("key"); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Expected ErrKeyMissing after delete, got %v", err)
+	}
+	if stats := db.CacheStats(); stats.Entries != 0 {
+		t.Errorf("Expected the deleted key to leave the cache, got %+v", stats)
+	}
+}
@@ -0,0 +1,290 @@
+package datastore
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const compactFilename = "compact-data-"
+
+// CompactionConfig controls when the sealed segments are merged in the
+// background. A zero field disables the corresponding trigger.
+type CompactionConfig struct {
+	// MaxSegments starts a compaction once this many sealed segments exist.
+	MaxSegments int
+	// GarbageRatio starts a compaction once this share of the sealed bytes
+	// belongs to overwritten records.
+	GarbageRatio float64
+}
+
+var DefaultCompaction = CompactionConfig{
+	MaxSegments:  4,
+	GarbageRatio: 0.5,
+}
+
+// removeCompactionLeftovers deletes temporary merged files that earlier
+// versions wrote under a separate name.
+func removeCompactionLeftovers(dir string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, compactFilename+"*"))
+	for _, path := range matches {
+		_ = os.Remove(path)
+	}
+}
+
+func (db *Database) notifyCompaction() {
+	select {
+	case db.compactChan <- struct{}{}:
+	default:
+	}
+}
+
+func (db *Database) compactHandler() {
+	defer db.bgWg.Done()
+
+	for range db.compactChan {
+		db.compactMu.Lock()
+		err := db.writeMissingHints()
+		db.mergeIndexRuns()
+		db.compactMu.Unlock()
+		if err != nil {
+			log.Printf("datastore: writing hint files failed: %s", err)
+		}
+
+		if !db.needsCompaction() {
+			continue
+		}
+		if err := db.Compact(); err != nil {
+			log.Printf("datastore: compaction failed: %s", err)
+		}
+	}
+}
+
+func (db *Database) needsCompaction() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	sealed := db.files[:len(db.files)-1]
+	if len(sealed) == 0 {
+		return false
+	}
+	cfg := db.opts.compaction
+	if cfg.MaxSegments > 1 && len(sealed) >= cfg.MaxSegments {
+		return true
+	}
+	if cfg.GarbageRatio <= 0 {
+		return false
+	}
+
+	var total, garbage int64
+	for _, seg := range sealed {
+		info, err := seg.file.Stat()
+		if err != nil {
+			return false
+		}
+		total += info.Size()
+		garbage += seg.garbage
+	}
+	return total > 0 && float64(garbage)/float64(total) >= cfg.GarbageRatio
+}
+
+// Compact merges all sealed segments into a single one that holds only the
+// latest value of every key. The active segment is left untouched, so Get and
+// Put keep working while the merged file is being written.
+//
+// The merged segment gets a fresh id and the manifest that lists it in place
+// of the sealed ones is the single commit point: until it is saved, Open
+// replays the sealed segments and removes the merged file as unlisted.
+func (db *Database) Compact() error {
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	db.mu.RLock()
+	sealed := slices.Clone(db.files[:len(db.files)-1])
+	db.mu.RUnlock()
+	if len(sealed) == 0 {
+		return nil
+	}
+
+	// Whatever has expired by now is left out of the merged file.
+	db.removeExpired("", 0)
+	merged := &segment{id: db.allocID(), version: currentFormat, hinted: true}
+	for _, seg := range sealed {
+		merged.maxSeq = max(merged.maxSeq, seg.maxSeq)
+	}
+	if err := db.writeMerged(merged, sealed); err != nil {
+		_ = os.Remove(db.segmentPath(merged.id))
+		return err
+	}
+
+	db.mu.Lock()
+	rest := db.files[len(sealed):]
+	db.files = append([]*segment{merged}, rest...)
+	if err := db.saveManifest(); err != nil {
+		db.files = slices.Concat(sealed, rest)
+		db.mu.Unlock()
+		// A failed sync may still have made the new manifest durable, so
+		// the merged file is left for Open to keep or remove.
+		_ = merged.file.Close()
+		return err
+	}
+	err := db.swapMerged(merged, sealed)
+	if err != nil {
+		// The merged segment is in place, but some keys may still point to
+		// the sealed ones, so they stay open until Close.
+		for _, seg := range sealed {
+			seg.refs++
+		}
+	}
+	db.retire(sealed)
+	db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Pinned segments keep their open handles, so snapshots can still read
+	// them after the names are gone.
+	for _, seg := range sealed {
+		_ = os.Remove(db.segmentPath(seg.id))
+		_ = os.Remove(db.hintPath(seg.id))
+	}
+	return nil
+}
+
+// retire closes the replaced segments that no snapshot reads from and keeps
+// the rest open until they are released. Callers must hold db.mu for writing.
+func (db *Database) retire(sealed []*segment) {
+	for _, seg := range sealed {
+		if seg.refs > 0 {
+			seg.retired = true
+			db.retired = append(db.retired, seg)
+			continue
+		}
+		_ = seg.file.Close()
+	}
+}
+
+// writeMerged copies the records of the sealed segments that the index still
+// points to into the merged segment, in the order they were written, and
+// lists them in its hint file. Records are streamed one at a time and each
+// key is looked up under a short read lock, so writers are not held up and
+// memory does not grow with the number of keys.
+//
+// Tombstones are left out: they only shadow records of older segments, and
+// the manifest update that lists the merged segment drops all of those at
+// once.
+func (db *Database) writeMerged(merged *segment, sealed []*segment) error {
+	f, err := os.OpenFile(db.segmentPath(merged.id), fileFlags|os.O_TRUNC, db.opts.fileMode)
+	if err != nil {
+		return err
+	}
+	hint, err := db.createHint(merged.id)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	offset := int64(segmentHeaderSize)
+	err = writeSegmentHeader(f)
+	w := bufio.NewWriter(io.NewOffsetWriter(f, offset))
+	for _, seg := range sealed {
+		if err != nil {
+			break
+		}
+		var sc *scanner
+		if sc, err = newScanner(seg.file); err != nil {
+			break
+		}
+		for pos, pair := range sc.entries() {
+			pos.seg = seg
+			if !db.isLive(pair.key, pos) {
+				continue
+			}
+			data := db.encodeRecord(pair, 0)
+			if _, err = w.Write(data); err != nil {
+				break
+			}
+			size := int64(len(data))
+			if err = hint.add(hintEntry{key: pair.key, offset: offset, size: size, expiresAt: pair.expiresAt}); err != nil {
+				break
+			}
+			offset += size
+		}
+		if err == nil && sc.err != nil && !isDamagedTail(sc.err) {
+			err = sc.err
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = hint.finish(offset, offset, merged.maxSeq)
+	} else {
+		hint.abort()
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	merged.file = f
+	merged.end = offset
+	return nil
+}
+
+// isLive tells whether the index still points to the record at pos.
+func (db *Database) isLive(key string, pos recordPos) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	cur, ok := db.records.get(key)
+	return ok && cur.seg == pos.seg && cur.offset == pos.offset
+}
+
+// swapMerged points the index at the merged copies of the records, read back
+// from the hint file of the merged segment. Keys written since the merge
+// point to newer segments and are left alone: while compaction runs, nothing
+// but a write or an expiry moves a key away from the sealed segments.
+// Callers must hold db.mu for writing.
+func (db *Database) swapMerged(merged *segment, sealed []*segment) error {
+	// The swap holds db.mu all along anyway. Merging the runs of a disk
+	// index as it goes keeps the lookups it makes from going through a run
+	// for every few thousand keys.
+	if d, ok := db.records.(*diskIndex); ok {
+		d.deferMerges = false
+		defer func() {
+			d.deferMerges = true
+		}()
+	}
+
+	f, err := os.Open(db.hintPath(merged.id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(f, int64(hintHeaderSize), info.Size()-int64(hintHeaderSize)-4))
+	for {
+		e, err := readHintEntry(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		pos := recordPos{seg: merged, offset: e.offset, size: e.size, expiresAt: e.expiresAt}
+		if cur, ok := db.records.get(e.key); ok && slices.Contains(sealed, cur.seg) {
+			db.records.set(e.key, pos)
+		} else {
+			merged.garbage += pos.size
+		}
+	}
+}
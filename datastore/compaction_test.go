@@ -0,0 +1,342 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openSmall(t *testing.T, dir string, cfg CompactionConfig) *Database {
+	t.Helper()
+	db, err := OpenWithOptions(dir, WithSegmentSize(1024), WithCompaction(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCompaction(t *testing.T) {
+	tmp := t.TempDir()
+	db := openSmall(t, tmp, CompactionConfig{})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 50; i++ {
+		for k := 0; k < 5; k++ {
+			if err := db.Put(fmt.Sprintf("key%d", k), fmt.Sprintf("%s-%d", value, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.Put("single", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	sizeBefore, err := db.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	sizeAfter, err := db.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sizeAfter >= sizeBefore {
+		t.Errorf("Size does not shrink after compaction (before %d, after %d)", sizeBefore, sizeAfter)
+	}
+	if len(db.files) != 2 {
+		t.Errorf("Expected merged and active segments, got %d files", len(db.files))
+	}
+
+	check := func() {
+		t.Helper()
+		for k := 0; k < 5; k++ {
+			key := fmt.Sprintf("key%d", k)
+			got, err := db.Get(key)
+			if err != nil {
+				t.Errorf("Cannot get %s: %s", key, err)
+			}
+			if want := value + "-49"; got != want {
+				t.Errorf("Get(%q) = %q, wanted %q", key, got, want)
+			}
+		}
+		if got, err := db.Get("single"); err != nil || got != "value" {
+			t.Errorf("Get(single) = %q, %v", got, err)
+		}
+	}
+	check()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = openSmall(t, tmp, CompactionConfig{})
+	check()
+}
+
+func TestBackgroundCompaction(t *testing.T) {
+	db := openSmall(t, t.TempDir(), CompactionConfig{MaxSegments: 3})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 100; i++ {
+		if err := db.Put("key", fmt.Sprintf("%s-%d", value, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		db.mu.RLock()
+		n := len(db.files)
+		db.mu.RUnlock()
+		if n <= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Background compaction did not run, %d segments left", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got, err := db.Get("key"); err != nil || got != value+"-99" {
+		t.Errorf("Get(key) = %q, %v", got, err)
+	}
+}
+
+func TestCompactionDropsTombstones(t *testing.T) {
+	tmp := t.TempDir()
+	db := openSmall(t, tmp, CompactionConfig{})
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := db.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put("kept", value); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	merged := db.files[0]
+	var keys []string
+	for item := range Stream(merged.file) {
+		keys = append(keys, item.key)
+	}
+	if len(keys) > 1 {
+		t.Errorf("Expected deleted keys to be dropped from the merged segment, got %v", keys)
+	}
+	if got, err := db.Get("kept"); err != nil || got != value {
+		t.Errorf("Get(kept) = %q, %v", got, err)
+	}
+	if _, err := db.Get("key0"); err != ErrKeyMissing {
+		t.Errorf("Expected ErrKeyMissing for a deleted key, got %v", err)
+	}
+
+	// Nothing that the dropped tombstones shadowed is replayed after a
+	// restart.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = openSmall(t, tmp, CompactionConfig{})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if keys := db.Keys("key"); len(keys) != 0 {
+		t.Errorf("Deleted keys %v are back after a restart", keys)
+	}
+}
+
+func TestCompactionCrashBeforeManifest(t *testing.T) {
+	tmp := t.TempDir()
+	db := openSmall(t, tmp, CompactionConfig{})
+
+	// roll fills the active segment until the database starts a new one.
+	roll := func() {
+		t.Helper()
+		n := len(db.files)
+		for i := 0; len(db.files) == n; i++ {
+			if err := db.Put(fmt.Sprintf("filler%d", i), strings.Repeat("f", 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.Put("key", "deleted value"); err != nil {
+		t.Fatal(err)
+	}
+	roll()
+	if err := db.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	roll()
+
+	// A crash right before the manifest is saved leaves the old manifest and
+	// every sealed segment that compaction has not replaced yet.
+	saved := make(map[string][]byte)
+	for _, seg := range db.files[:len(db.files)-1] {
+		saved[db.segmentPath(seg.id)] = nil
+	}
+	saved[filepath.Join(tmp, manifestName)] = nil
+	for path := range saved {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved[path] = data
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for path, data := range saved {
+		if _, err := os.Stat(path); err == nil && filepath.Base(path) != manifestName {
+			continue
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db = openSmall(t, tmp, CompactionConfig{})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if value, err := db.Get("key"); err != ErrKeyMissing {
+		t.Errorf("Expected the deleted key to stay deleted, got %q, %v", value, err)
+	}
+	if value, err := db.Get("filler0"); err != nil || value != strings.Repeat("f", 100) {
+		t.Errorf("Get(filler0) = %q, %v", value, err)
+	}
+}
+
+// openDiskIndexed opens dir with the disk index and a small memory limit.
+func openDiskIndexed(t *testing.T, dir string) *Database {
+	t.Helper()
+	db, err := OpenWithOptions(dir, WithSegmentSize(256<<10), WithCompaction(CompactionConfig{}), WithDiskIndex(64<<10), func(o *options) {
+		o.indexMemLimit = 8192
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// putKeys writes n keys in batches of a thousand.
+func putKeys(t *testing.T, db *Database, n int) {
+	t.Helper()
+	for i := 0; i < n; i += 1000 {
+		b := db.NewBatch()
+		for j := i; j < i+1000; j++ {
+			b.Put(fmt.Sprintf("key%07d", j), "value")
+		}
+		if err := b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// heapGrowth returns how much the heap grows at most while f runs.
+func heapGrowth(t *testing.T, f func() error) uint64 {
+	t.Helper()
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	base, peak := stats.HeapAlloc, stats.HeapAlloc
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapAlloc)
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+	err := f()
+	close(done)
+	<-sampled
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peak - base
+}
+
+// compactionGrowth returns how much the heap grows while the sealed segments
+// holding n keys are compacted with the disk index.
+func compactionGrowth(t *testing.T, n int) uint64 {
+	t.Helper()
+	db := openDiskIndexed(t, t.TempDir())
+	defer db.Close()
+	putKeys(t, db, n)
+	return heapGrowth(t, db.Compact)
+}
+
+// openGrowth returns how much the heap grows while a directory holding n
+// keys in a single merged segment is opened with the disk index.
+func openGrowth(t *testing.T, n int) uint64 {
+	t.Helper()
+	dir := t.TempDir()
+	db := openDiskIndexed(t, dir)
+	putKeys(t, db, n)
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return heapGrowth(t, func() error {
+		db = openDiskIndexed(t, dir)
+		return db.Close()
+	})
+}
+
+func TestCompactionMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a lot of keys")
+	}
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+
+	small := compactionGrowth(t, 10000)
+	large := compactionGrowth(t, 40000)
+	if large > small+2<<20 {
+		t.Errorf("Compaction memory grows with the number of keys: %d bytes for 10000 keys, %d for 40000", small, large)
+	}
+}
+
+func TestOpenMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a lot of keys")
+	}
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+
+	small := openGrowth(t, 10000)
+	large := openGrowth(t, 40000)
+	if large > small+2<<20 {
+		t.Errorf("Open memory grows with the number of keys: %d bytes for 10000 keys, %d for 40000", small, large)
+	}
+}
@@ -0,0 +1,74 @@
+package datastore
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"sync"
+)
+
+// flagCompressed tells that the value is stored deflated. The value length
+// in the header is the length of the compressed data.
+const flagCompressed byte = 1 << 5
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+// deflate compresses the value. It reports false if that does not make the
+// value any smaller, such values are better stored as they are.
+func deflate(value string) (string, bool) {
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := io.WriteString(w, value); err != nil {
+		return "", false
+	}
+	if err := w.Close(); err != nil || buf.Len() >= len(value) {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// inflate decompresses a value stored with flagCompressed.
+func inflate(data []byte) (string, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	var value strings.Builder
+	n, err := io.Copy(&value, io.LimitReader(r, maxEntrySize+1))
+	if err != nil || n > maxEntrySize {
+		return "", ErrCorrupted
+	}
+	return value.String(), nil
+}
+
+// encodeRecord encodes a record the way the database writes it: values of at
+// least the configured threshold are compressed when that saves space.
+func (db *Database) encodeRecord(pair kvPair, flags byte) []byte {
+	if t := db.opts.compressThreshold; t > 0 && len(pair.value) >= t {
+		if packed, ok := deflate(pair.value); ok {
+			pair.value = packed
+			flags |= flagCompressed
+		}
+	}
+	return encode(pair, flags)
+}
+
+// encodedSize returns the size of the pair encoded without compression in the
+// given format.
+func encodedSize(pair kvPair, version byte) int64 {
+	n := int64(len(pair.key) + len(pair.value))
+	if version == formatLegacy {
+		return n + 8
+	}
+	if pair.expiresAt != 0 {
+		n += expirySize
+	}
+	return n + headerSize(version)
+}
@@ -0,0 +1,86 @@
+package datastore
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	tmp := t.TempDir()
+	reopen := func() *Database {
+		db, err := OpenWithOptions(tmp, WithSegmentSize(1024), WithCompression(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	db := reopen()
+
+	long := strings.Repeat(`{"team":"kv","value":42}`, 50)
+	for key, value := range map[string]string{"short": "plain", "long": long} {
+		if err := db.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(db *Database) {
+		t.Helper()
+		for key, expected := range map[string]string{"short": "plain", "long": long} {
+			if value, err := db.Get(key); err != nil || value != expected {
+				t.Errorf("Get(%q) = %d bytes, %v", key, len(value), err)
+			}
+		}
+	}
+	check(db)
+
+	if size, err := db.Size(); err != nil || size >= int64(len(long)) {
+		t.Errorf("Expected the long value to be stored compressed, size is %d, %v", size, err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopen()
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	check(db)
+
+	paths, err := SegmentPaths(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewSegmentReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := make(map[string]int64)
+	for pos, pair := range r.Records() {
+		saved[pair.Key()] = pos.Saved
+	}
+	if saved["short"] != 0 || saved["long"] <= 0 {
+		t.Errorf("Unexpected saved bytes %v", saved)
+	}
+}
+
+func TestDeflateIncompressible(t *testing.T) {
+	if _, ok := deflate("abc"); ok {
+		t.Error("Expected a tiny value to be left as is")
+	}
+	packed, ok := deflate(strings.Repeat("a", 1000))
+	if !ok {
+		t.Fatal("Expected a repetitive value to be compressed")
+	}
+	value, err := inflate([]byte(packed))
+	if err != nil || value != strings.Repeat("a", 1000) {
+		t.Errorf("inflate() = %d bytes, %v", len(value), err)
+	}
+	if _, err := inflate([]byte("not deflate data")); err != ErrCorrupted {
+		t.Errorf("Expected ErrCorrupted for bad data, got %v", err)
+	}
+}
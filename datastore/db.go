@@ -4,8 +4,11 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -14,30 +17,104 @@ const (
 	fileFlags    = os.O_RDWR | os.O_CREATE
 )
 
-const readerLimit = 10
+const (
+	readerLimit = 10
+	queueDepth  = 100
+)
 
 var ErrKeyMissing = errors.New("key not found")
 
+type segment struct {
+	id      int
+	file    *os.File
+	version byte
+	garbage int64
+	// hinted is set once the segment has an up-to-date hint file.
+	hinted bool
+	// end is the offset right after the last complete record. It is
+	// guarded by db.mu.
+	end int64
+	// replicable is set for segments whose offsets followers can rely on:
+	// the ones written by this process and the active one found by Open.
+	// Compaction and an earlier process may have rewritten the others.
+	replicable bool
+	// refs counts the snapshots that read from the segment. A segment
+	// replaced by compaction stays open until the last of them is released.
+	// Both fields are guarded by db.mu.
+	refs    int
+	retired bool
+	// maxSeq is the highest sequence number written to the segment,
+	// including records that compaction has dropped since.
+	maxSeq uint64
+}
+
 type recordPos struct {
-	file   *os.File
+	seg    *segment
 	offset int64
+	size   int64
+	// expiresAt is copied from the record, so expired keys are recognised
+	// without reading the segment.
+	expiresAt int64
+}
+
+func (p recordPos) expired(now int64) bool {
+	return p.expiresAt != 0 && now >= p.expiresAt
+}
+
+// RecoveryStats describes the damaged segment tails found by Open.
+type RecoveryStats struct {
+	// Recovered is the number of bytes of valid records kept in damaged
+	// segments.
+	Recovered int64
+	// Discarded is the number of bytes after the last valid record that are
+	// no longer served.
+	Discarded int64
 }
 
 type Database struct {
-	dir     string
-	files   []*os.File
-	records map[string]recordPos
+	dir      string
+	opts     options
+	files    []*segment
+	records  index
+	nextID   int
+	recovery RecoveryStats
+	// seq is the sequence number of the latest record. After Open only the
+	// writer goroutine changes it.
+	seq uint64
+	// expiring counts the keys in records that have an expiry, so a sweep
+	// can skip an index without any.
+	expiring int
+	// cache, if enabled, holds recently read values.
+	cache *valueCache
+	// retired holds segments removed by compaction that are still pinned by
+	// snapshots.
+	retired []*segment
 
 	mu        sync.RWMutex
 	writeChan chan writeRequest
 	readChan  chan readRequest
 	wg        sync.WaitGroup
+
+	compactMu   sync.Mutex
+	compactChan chan struct{}
+
+	watchers watchers
+
+	// done stops the background goroutines tracked by bgWg.
+	done chan struct{}
+	bgWg sync.WaitGroup
 }
 
+// writeRequest carries records that are appended together. With more than
+// one record they form a batch that restore applies all or none of.
 type writeRequest struct {
-	key   string
-	value string
-	resp  chan error
+	pairs []kvPair
+	// prepare, if set, runs in the writer goroutine right before the write
+	// and returns the records to append instead of pairs. Since there is
+	// only one writer, it sees the latest state and nothing can change it
+	// until the records are written.
+	prepare func() ([]kvPair, error)
+	resp    chan error
 }
 
 type readRequest struct {
@@ -46,58 +123,173 @@ type readRequest struct {
 }
 
 type readResult struct {
-	value string
-	err   error
+	value Value
+	seq   uint64
+	// expiresAt is the expiry of the record, so that operations rewriting
+	// the key can keep it.
+	expiresAt int64
+	err       error
 }
 
 func Open(dir string) (*Database, error) {
+	return open(dir, defaultOptions())
+}
+
+func OpenWithOptions(dir string, opts ...Option) (*Database, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return open(dir, o)
+}
+
+func open(dir string, opts options) (*Database, error) {
 	db := &Database{
-		dir:       dir,
-		files:     []*os.File{},
-		records:   make(map[string]recordPos),
-		writeChan: make(chan writeRequest, 100),
-		readChan:  make(chan readRequest, 100),
+		dir:         dir,
+		opts:        opts,
+		files:       []*segment{},
+		writeChan:   make(chan writeRequest, opts.queueDepth),
+		readChan:    make(chan readRequest, opts.queueDepth),
+		compactChan: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		cache:       newValueCache(opts.cacheSize),
 	}
 
-	matches, _ := filepath.Glob(filepath.Join(dir, baseFilename+"*"))
-	for _, path := range matches {
-		f, err := os.OpenFile(path, os.O_RDWR, 0o600)
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := removeUnlisted(dir, m); err != nil {
+		return nil, err
+	}
+	removeCompactionLeftovers(dir)
+	removeIndexRuns(dir)
+	removeOrphanHints(dir, m.ids)
+	if opts.diskIndex {
+		db.records = newDiskIndex(dir, opts.fileMode, opts.indexCache, opts.indexMemLimit)
+	} else {
+		db.records = &treap{}
+	}
+	db.nextID = m.next
+
+	ids := m.ids
+	for i, id := range ids {
+		path := db.segmentPath(id)
+		f, err := os.OpenFile(path, os.O_RDWR, opts.fileMode)
 		if err != nil {
 			db.Close()
 			return nil, errors.New("failed to open: " + path)
 		}
-		db.files = append(db.files, f)
-		if err := db.restore(f); err != nil {
+		seg := &segment{id: id, file: f}
+		db.files = append(db.files, seg)
+		if err := db.restore(seg, i == len(ids)-1); err != nil {
 			db.Close()
 			return nil, err
 		}
 	}
 
-	if len(db.files) == 0 {
-		f, err := db.createNewFile()
+	// Old segments stay readable, but new records are only appended in the
+	// current format.
+	if len(db.files) == 0 || db.files[len(db.files)-1].version != currentFormat {
+		seg, err := db.createNewFile()
 		if err != nil {
+			db.Close()
 			return nil, err
 		}
-		db.files = append(db.files, f)
+		db.files = append(db.files, seg)
 	}
+	if err := db.records.err(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if d, ok := db.records.(*diskIndex); ok {
+		d.deferMerges = true
+	}
+	db.files[len(db.files)-1].replicable = true
+	// This also migrates directories that have no manifest yet.
+	if err := db.saveManifest(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	db.watchers.seq = db.seq
 
 	db.wg.Add(1)
 	go db.writeHandler()
 
-	for i := 0; i < readerLimit; i++ {
+	for i := 0; i < opts.readers; i++ {
 		db.wg.Add(1)
 		go db.readHandler()
 	}
 
+	db.bgWg.Add(1)
+	go db.compactHandler()
+
+	if opts.sync == SyncPeriodic {
+		db.bgWg.Add(1)
+		go db.periodicSync()
+	}
+
+	db.bgWg.Add(1)
+	go db.expireHandler()
+
 	return db, nil
 }
 
+// segmentIDs returns ids of the segment files in dir in the order they were
+// written. Glob alone sorts names lexicographically, so "10" would precede "2".
+func segmentIDs(dir string) ([]int, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, baseFilename+"*"))
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, path := range matches {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), baseFilename))
+		if err != nil || id < 0 {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
 func (db *Database) writeHandler() {
 	defer db.wg.Done()
 
 	for req := range db.writeChan {
-		err := db.writeToFile(req.key, req.value)
-		req.resp <- err
+		batch := []writeRequest{req}
+		if db.opts.sync == SyncBatch {
+			batch = db.pendingWrites(batch)
+		}
+
+		errs := make([]error, len(batch))
+		written := make([][]kvPair, len(batch))
+		for i, r := range batch {
+			written[i], errs[i] = db.writeToFile(r)
+		}
+		if db.opts.sync == SyncAlways || db.opts.sync == SyncBatch {
+			if err := db.syncActive(); err != nil {
+				for i := range errs {
+					if errs[i] == nil {
+						errs[i] = err
+					}
+				}
+			}
+		}
+
+		// Watchers only hear about writes that are acknowledged, so with
+		// a syncing mode an event never announces a record that may be lost.
+		for i, r := range batch {
+			if errs[i] == nil {
+				db.publish(written[i])
+			}
+			r.resp <- errs[i]
+		}
+		db.notifyCompaction()
 	}
 }
 
@@ -105,106 +297,377 @@ func (db *Database) readHandler() {
 	defer db.wg.Done()
 
 	for req := range db.readChan {
-		db.mu.RLock()
-		pos, ok := db.records[req.key]
-		db.mu.RUnlock()
+		req.resp <- db.read(req.key)
+	}
+}
 
-		if !ok {
-			req.resp <- readResult{"", ErrKeyMissing}
-			continue
+// read holds the read lock for the whole lookup so that compaction cannot
+// swap and close the segment files underneath it.
+func (db *Database) read(key string) readResult {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pos, ok := db.records.get(key)
+	if !ok {
+		if err := db.records.err(); err != nil {
+			return readResult{err: err}
 		}
+		return readResult{err: ErrKeyMissing}
+	}
+	if pos.expired(time.Now().UnixNano()) {
+		return readResult{err: ErrKeyMissing}
+	}
+	if value, seq, ok := db.cache.get(key); ok {
+		return readResult{value, seq, pos.expiresAt, nil}
+	}
+	value, seq, err := readValue(pos)
+	if err == nil {
+		db.cache.add(key, value, seq)
+	}
+	return readResult{value, seq, pos.expiresAt, err}
+}
+
+func readValue(pos recordPos) (Value, uint64, error) {
+	e, err := readAt(pos)
+	if err != nil {
+		return Value{}, 0, err
+	}
+	return Value{e.kind, e.value}, e.seq, nil
+}
 
-		f, err := os.Open(pos.file.Name())
+// writeToFile appends the records of the request and returns them with their
+// sequence numbers.
+func (db *Database) writeToFile(req writeRequest) ([]kvPair, error) {
+	if req.prepare != nil {
+		pairs, err := req.prepare()
 		if err != nil {
-			req.resp <- readResult{"", err}
-			continue
+			return nil, err
 		}
+		req.pairs = pairs
+	}
+	if len(req.pairs) == 0 {
+		return nil, nil
+	}
+	req.pairs = db.number(req.pairs)
 
-		e, err := LoadEntry(f, pos.offset)
-		f.Close()
+	// Records go right after the last complete one rather than to the end of
+	// the file, so the bytes of a failed write never end up between them.
+	db.mu.RLock()
+	latest := db.files[len(db.files)-1]
+	offset := latest.end
+	db.mu.RUnlock()
+
+	if offset >= db.opts.segmentSize {
+		if db.opts.sync != SyncNone {
+			if err := latest.file.Sync(); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		latest, err = db.addSegment()
 		if err != nil {
-			req.resp <- readResult{"", err}
-			continue
+			return nil, err
+		}
+		offset = segmentHeaderSize
+	}
+
+	// The whole batch goes to the file in a single write. Every record but the
+	// last one is flagged, so a batch cut short by a crash is recognised.
+	var data []byte
+	sizes := make([]int64, len(req.pairs))
+	for i, pair := range req.pairs {
+		var flags byte
+		if i < len(req.pairs)-1 {
+			flags |= flagBatch
+		}
+		record := db.encodeRecord(pair, flags)
+		sizes[i] = int64(len(record))
+		data = append(data, record...)
+	}
+	if _, err := latest.file.WriteAt(data, offset); err != nil {
+		_ = latest.file.Truncate(offset)
+		return nil, err
+	}
+
+	db.mu.Lock()
+	now := time.Now().UnixNano()
+	for i, pair := range req.pairs {
+		db.setRecord(pair, recordPos{seg: latest, offset: offset, size: sizes[i]})
+		db.cache.update(pair, now)
+		offset += sizes[i]
+	}
+	latest.end = offset
+	latest.maxSeq = max(latest.maxSeq, db.seq)
+	db.mu.Unlock()
+
+	if err := db.records.err(); err != nil {
+		return nil, err
+	}
+	return req.pairs, nil
+}
+
+// number assigns the next sequence numbers to a copy of the pairs. Records
+// that already carry one, such as those received from a leader, keep it.
+func (db *Database) number(pairs []kvPair) []kvPair {
+	pairs = slices.Clone(pairs)
+	for i := range pairs {
+		if pairs[i].seq == 0 {
+			db.seq++
+			pairs[i].seq = db.seq
 		}
+		db.seq = max(db.seq, pairs[i].seq)
+	}
+	return pairs
+}
 
-		req.resp <- readResult{e.value, nil}
+// setRecord applies a record written at pos to the index and accounts the
+// replaced record as garbage. A tombstone is garbage itself as soon as it is
+// written, compaction drops it together with the values it shadows. So is a
+// record that has already expired.
+// Callers must hold db.mu for writing.
+func (db *Database) setRecord(pair kvPair, pos recordPos) {
+	if old, ok := db.records.get(pair.key); ok {
+		old.seg.garbage += old.size
+		if old.expiresAt != 0 {
+			db.expiring--
+		}
+	}
+	if pair.deleted || pair.expired(time.Now().UnixNano()) {
+		pos.seg.garbage += pos.size
+		db.records.delete(pair.key)
+		return
+	}
+	pos.expiresAt = pair.expiresAt
+	if pos.expiresAt != 0 {
+		db.expiring++
 	}
+	db.records.set(pair.key, pos)
 }
 
-func (db *Database) writeToFile(key, value string) error {
-	latest := db.files[len(db.files)-1]
-	info, err := latest.Stat()
+// restore replays the records of a segment into the index. Sealed segments
+// are loaded from their hint files when possible and get one written after a
+// full scan otherwise.
+//
+// If the segment ends with a torn or corrupted record, everything after the
+// last valid one is discarded. The tail of the last segment is truncated, so
+// that new records are appended right after valid data and stay reachable on
+// the next restart. Tails of older segments are only accounted as garbage
+// and go away with compaction.
+func (db *Database) restore(seg *segment, last bool) error {
+	info, err := seg.file.Stat()
 	if err != nil {
 		return err
 	}
+	if info.Size() == 0 {
+		seg.version = currentFormat
+		seg.end = segmentHeaderSize
+		return writeSegmentHeader(seg.file)
+	}
 
-	offset := info.Size()
-	if offset >= maxSize {
-		latest, err = db.createNewFile()
-		if err != nil {
+	sc, err := newScanner(seg.file)
+	if err != nil {
+		return err
+	}
+	seg.version = sc.version
+	if !last {
+		if ok, err := db.restoreFromHint(seg, info.Size()); ok || err != nil {
 			return err
 		}
-		db.files = append(db.files, latest)
-		offset = 0
 	}
 
-	data := Serialize(kvPair{key, value})
-	if _, err := latest.WriteAt(data, offset); err != nil {
-		return err
+	// The hint of a sealed segment is written as the segment is scanned. A
+	// hint that cannot be written only costs the next Open another scan.
+	var hint *hintWriter
+	if !last {
+		hint, _ = db.createHint(seg.id)
+	}
+	for pos, item := range sc.entries() {
+		pos.seg = seg
+		db.setRecord(item, pos)
+		seg.maxSeq = max(seg.maxSeq, item.seq)
+		if hint != nil && hint.add(hintEntry{item.key, pos.offset, pos.size, item.expiresAt, item.deleted}) != nil {
+			hint.abort()
+			hint = nil
+		}
 	}
 
-	db.mu.Lock()
-	db.records[key] = recordPos{latest, offset}
-	db.mu.Unlock()
+	if sc.err != nil && !isDamagedTail(sc.err) {
+		if hint != nil {
+			hint.abort()
+		}
+		return sc.err
+	}
+	seg.end = sc.end
+	db.seq = max(db.seq, seg.maxSeq)
+	if hint != nil && hint.finish(info.Size(), sc.end, seg.maxSeq) == nil {
+		seg.hinted = true
+	}
+	if sc.err == nil {
+		return nil
+	}
 
-	return nil
+	discarded := info.Size() - sc.end
+	db.recovery.Recovered += sc.end
+	db.recovery.Discarded += discarded
+	if !last {
+		seg.garbage += discarded
+		return nil
+	}
+	if err := seg.file.Truncate(sc.end); err != nil {
+		return err
+	}
+	return seg.file.Sync()
 }
 
-func (db *Database) restore(f *os.File) error {
-	var offset int64
-	for item := range Stream(f) {
-		db.records[item.key] = recordPos{f, offset}
-		offset += int64(len(item.key) + len(item.value) + 8)
-	}
-	return nil
+// Recovery reports what Open had to discard from damaged segments.
+func (db *Database) Recovery() RecoveryStats {
+	return db.recovery
 }
 
 func (db *Database) Close() error {
 	close(db.writeChan)
 	close(db.readChan)
 	db.wg.Wait()
+	db.closeWatchers()
+
+	close(db.compactChan)
+	close(db.done)
+	db.bgWg.Wait()
 
-	for _, f := range db.files {
-		if err := f.Close(); err != nil {
+	if db.opts.sync != SyncNone && len(db.files) > 0 {
+		if err := db.syncActive(); err != nil {
+			return err
+		}
+	}
+	if db.records != nil {
+		db.records.release()
+	}
+	for _, seg := range append(db.files, db.retired...) {
+		if err := seg.file.Close(); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (db *Database) createNewFile() (*os.File, error) {
-	name := baseFilename + strconv.Itoa(len(db.files))
-	fullPath := filepath.Join(db.dir, name)
-	return os.OpenFile(fullPath, fileFlags, 0o600)
+func (db *Database) segmentPath(id int) string {
+	return filepath.Join(db.dir, baseFilename+strconv.Itoa(id))
+}
+
+// addSegment starts a new active segment and records it in the manifest. A
+// segment the manifest does not list would be removed by the next Open, so
+// nothing is written to it before the manifest is saved.
+func (db *Database) addSegment() (*segment, error) {
+	seg, err := db.createNewFile()
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	db.files = append(db.files, seg)
+	err = db.saveManifest()
+	if err != nil {
+		db.files = db.files[:len(db.files)-1]
+	}
+	db.mu.Unlock()
+
+	if err != nil {
+		_ = seg.file.Close()
+		_ = os.Remove(seg.file.Name())
+		return nil, err
+	}
+	return seg, nil
+}
+
+func (db *Database) createNewFile() (*segment, error) {
+	id := db.allocID()
+	f, err := os.OpenFile(db.segmentPath(id), fileFlags, db.opts.fileMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSegmentHeader(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &segment{id: id, file: f, version: currentFormat, end: segmentHeaderSize, replicable: true}, nil
+}
+
+// allocID reserves an id for a new segment. Ids are never reused, so a file
+// left behind by a crash cannot be mistaken for a listed segment.
+func (db *Database) allocID() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	id := db.nextID
+	db.nextID++
+	return id
 }
 
+// Get returns a string value. Use GetValue for values of other types.
 func (db *Database) Get(key string) (string, error) {
+	value, err := db.GetValue(key)
+	if err != nil {
+		return "", err
+	}
+	return value.AsString()
+}
+
+func (db *Database) Put(key, value string) error {
+	return db.PutValue(key, StringValue(value))
+}
+
+func (db *Database) GetValue(key string) (Value, error) {
+	value, _, err := db.GetWithVersion(key)
+	return value, err
+}
+
+// GetWithVersion returns the value together with the sequence number of the
+// record that holds it. The number changes with every write to the key.
+func (db *Database) GetWithVersion(key string) (Value, uint64, error) {
 	resp := make(chan readResult)
 	db.readChan <- readRequest{key, resp}
 	result := <-resp
-	return result.value, result.err
+	return result.value, result.seq, result.err
 }
 
-func (db *Database) Put(key, value string) error {
-	resp := make(chan error)
-	db.writeChan <- writeRequest{key, value, resp}
-	return <-resp
+func (db *Database) PutValue(key string, value Value) error {
+	return db.write(writeRequest{pairs: []kvPair{value.pair(key)}})
+}
+
+// Delete removes the key by appending a tombstone record. It returns
+// ErrKeyMissing if there is nothing to delete.
+func (db *Database) Delete(key string) error {
+	return db.write(writeRequest{prepare: func() ([]kvPair, error) {
+		if _, ok := db.lookup(key); !ok {
+			return nil, ErrKeyMissing
+		}
+		return []kvPair{{key: key, deleted: true}}, nil
+	}})
+}
+
+// lookup returns the position of the key unless it is absent or expired.
+func (db *Database) lookup(key string) (recordPos, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	pos, ok := db.records.get(key)
+	if !ok || pos.expired(time.Now().UnixNano()) {
+		return recordPos{}, false
+	}
+	return pos, true
+}
+
+func (db *Database) write(req writeRequest) error {
+	req.resp = make(chan error)
+	db.writeChan <- req
+	return <-req.resp
 }
 
 func (db *Database) Size() (int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	var total int64
-	for _, f := range db.files {
-		stat, err := f.Stat()
+	for _, seg := range db.files {
+		stat, err := seg.file.Stat()
 		if err != nil {
 			return 0, err
 		}
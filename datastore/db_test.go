@@ -1,6 +1,13 @@
 package datastore
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -82,3 +89,360 @@ func TestDb(t *testing.T) {
 		}
 	})
 }
+
+func TestDbCorruptedRecord(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("k2", "v2"); err != nil {
+		t.Fatal(err)
+	}
+
+	pos := mustPos(t, db, "k1")
+	if _, err := pos.seg.file.WriteAt([]byte{'X'}, pos.offset+pos.size-1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Get("k1"); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected ErrCorrupted, got %v", err)
+	}
+	if value, err := db.Get("k2"); err != nil || value != "v2" {
+		t.Errorf("Get(k2) = %q, %v", value, err)
+	}
+}
+
+func TestDbLegacySegment(t *testing.T) {
+	tmp := t.TempDir()
+
+	var data []byte
+	for _, pair := range [][]string{{"k1", "old"}, {"k2", "v2"}, {"k1", "v1"}} {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(pair[0])))
+		data = append(data, pair[0]...)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(pair[1])))
+		data = append(data, pair[1]...)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, baseFilename+"0"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for key, expected := range map[string]string{"k1": "v1", "k2": "v2"} {
+		value, err := db.Get(key)
+		if err != nil || value != expected {
+			t.Errorf("Get(%q) = %q, %v, wanted %q", key, value, err, expected)
+		}
+	}
+
+	if err := db.Put("k3", "v3"); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.files) != 2 || db.files[1].version != currentFormat {
+		t.Errorf("Expected new writes to go to a new segment in the current format")
+	}
+}
+
+func TestDbDelete(t *testing.T) {
+	tmp := t.TempDir()
+	db := openSmall(t, tmp, CompactionConfig{})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("k2", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("k1"); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Expected ErrKeyMissing when deleting twice, got %v", err)
+	}
+	if _, err := db.Get("k1"); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Expected ErrKeyMissing after delete, got %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = openSmall(t, tmp, CompactionConfig{})
+
+	if _, err := db.Get("k1"); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Expected deleted key to stay missing after restart, got %v", err)
+	}
+	if value, err := db.Get("k2"); err != nil || value != "v2" {
+		t.Errorf("Get(k2) = %q, %v", value, err)
+	}
+}
+
+func TestDbTornTail(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for _, pair := range [][]string{{"k1", "v1"}, {"k2", "v2"}, {"k3", "v3"}} {
+		if err := db.Put(pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	torn := mustPos(t, db, "k3")
+	path := torn.seg.file.Name()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash in the middle of writing the last record.
+	if err := os.Truncate(path, torn.offset+torn.size/2); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := db.Recovery()
+	if stats.Recovered != torn.offset || stats.Discarded != torn.size/2 {
+		t.Errorf("Unexpected recovery stats %+v, wanted %d recovered and %d discarded", stats, torn.offset, torn.size/2)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != torn.offset {
+		t.Errorf("Expected segment to be truncated to %d bytes", torn.offset)
+	}
+	if _, err := db.Get("k3"); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Expected torn record to be dropped, got %v", err)
+	}
+
+	if err := db.Put("k4", "v4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := db.Recovery(); stats != (RecoveryStats{}) {
+		t.Errorf("Expected a clean restart, got %+v", stats)
+	}
+	for key, expected := range map[string]string{"k1": "v1", "k2": "v2", "k4": "v4"} {
+		value, err := db.Get(key)
+		if err != nil || value != expected {
+			t.Errorf("Get(%q) = %q, %v, wanted %q", key, value, err, expected)
+		}
+	}
+}
+
+func TestDbFailedWrite(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write that failed halfway, for example on a full disk, leaves part of
+	// a record behind.
+	pos := mustPos(t, db, "k1")
+	if _, err := pos.seg.file.WriteAt([]byte("torn record"), pos.offset+pos.size); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("k2", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if value, err := db.Get("k2"); err != nil || value != "v2" {
+		t.Errorf("Expected the record written after the failure to survive a restart, got %q, %v", value, err)
+	}
+}
+
+func mustPos(t *testing.T, db *Database, key string) recordPos {
+	t.Helper()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	pos, ok := db.records.get(key)
+	if !ok {
+		t.Fatalf("No record for %q", key)
+	}
+	return pos
+}
+
+func TestDbKeysAndScan(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for _, key := range []string{"team/b", "other", "team/a", "team/c", "teams"} {
+		if err := db.Put(key, "v-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("team/c"); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := db.Keys("team/")
+	if !reflect.DeepEqual(keys, []string{"team/a", "team/b"}) {
+		t.Errorf("Keys(team/) = %v", keys)
+	}
+
+	var scanned []string
+	for key, value := range db.Scan("other", "team/b") {
+		if value != StringValue("v-"+key) {
+			t.Errorf("Scan yields %q for %q", value, key)
+		}
+		scanned = append(scanned, key)
+	}
+	if !reflect.DeepEqual(scanned, []string{"other", "team/a"}) {
+		t.Errorf("Scan(other, team/b) = %v", scanned)
+	}
+}
+
+func TestDbSeq(t *testing.T) {
+	tmp := t.TempDir()
+	db := openSmall(t, tmp, CompactionConfig{})
+
+	value := strings.Repeat("v", 100)
+	var last uint64
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key%d", i%5)
+		if err := db.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		_, seq, err := db.GetWithVersion(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq <= last {
+			t.Fatalf("Sequence number %d after %d", seq, last)
+		}
+		last = seq
+	}
+	if err := db.Delete("key0"); err != nil {
+		t.Fatal(err)
+	}
+	// Compaction drops the tombstone, its number must not be reused.
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openSmall(t, tmp, CompactionConfig{})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if seq := db.LastSeq(); seq != last+1 {
+		t.Errorf("LastSeq() = %d after restart, wanted %d", seq, last+1)
+	}
+	if err := db.Put("key0", value); err != nil {
+		t.Fatal(err)
+	}
+	if _, seq, err := db.GetWithVersion("key0"); err != nil || seq != last+2 {
+		t.Errorf("GetWithVersion(key0) = %d, %v, wanted %d", seq, err, last+2)
+	}
+}
+
+func benchmarkDB(b *testing.B) (*Database, []string) {
+	db, err := Open(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		_ = db.Close()
+	})
+	keys := make([]string, 1000)
+	value := strings.Repeat("v", 256)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		if err := db.Put(keys[i], value); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return db, keys
+}
+
+func BenchmarkGet(b *testing.B) {
+	db, keys := benchmarkDB(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, err := db.Get(keys[i%len(keys)]); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkReadRecord compares reading a record through the shared segment
+// handle in a single ReadAt with opening the file for every read and parsing
+// the header first, as Get used to do.
+func BenchmarkReadRecord(b *testing.B) {
+	db, keys := benchmarkDB(b)
+	positions := make([]recordPos, len(keys))
+	for i, key := range keys {
+		positions[i], _ = db.lookup(key)
+	}
+
+	b.Run("reopen", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			pos := positions[i%len(positions)]
+			f, err := os.Open(pos.seg.file.Name())
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, _, err = loadEntry(f, pos.offset, pos.seg.version)
+			f.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("shared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := readAt(positions[i%len(positions)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,599 @@
+package datastore
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// The disk index keeps the positions of most keys in sorted run files next
+// to the segments, so its memory use does not grow with the number of keys:
+//
+//   - recent changes live in a small treap, deleted keys stay there as
+//     positions without a segment until they reach the runs;
+//   - once the treap is full it is written out as a new run, and runs of
+//     similar size are merged in the background, so there are only a
+//     logarithmic number of them to look into;
+//   - every run is cut into blocks of about indexBlockSize bytes, only the
+//     first key of each block is kept in memory, and recently read blocks
+//     are cached.
+//
+// Run files never change once written. A view shares them with the index it
+// was made from, so every run counts the indexes that use it and its file is
+// removed once the last one is released. The runs are not durable: Open
+// removes them and rebuilds the index from the segments.
+const (
+	indexRunFilename = "index-run-"
+	indexBlockSize   = 4096
+	indexMemLimit    = 1 << 16
+
+	// runEntrySize is the fixed part of a run entry:
+	// key length | segment ref | offset | size | expiry | key.
+	runEntrySize = 4 + 4 + 8 + 8 + 8
+)
+
+type diskIndex struct {
+	shared   *indexShared
+	memLimit int
+	mem      treap
+	// runs are ordered from the newest to the oldest.
+	runs []*indexRun
+	size int
+	// deferMerges leaves merging runs to Database.mergeIndexRuns, so that
+	// a write never waits for a merge that rewrites most of the index.
+	// Open and the index swap of a compaction merge as they go.
+	deferMerges bool
+}
+
+// indexShared is what an index shares with its views.
+type indexShared struct {
+	dir   string
+	perm  os.FileMode
+	cache *blockCache
+
+	mu sync.Mutex
+	// Segments are stored in runs as small numbers, zero for a deleted key.
+	refs     map[*segment]uint32
+	segments []*segment
+	nextRun  int
+	failure  error
+}
+
+type indexRun struct {
+	id     int
+	file   *os.File
+	blocks []runBlock
+	// entries counts the keys in the run, deleted ones included.
+	entries int
+	// users is the number of indexes that read the run, guarded by
+	// indexShared.mu.
+	users int
+}
+
+type runBlock struct {
+	first  string
+	offset int64
+	size   int
+}
+
+type runEntry struct {
+	key string
+	pos recordPos
+}
+
+func removeIndexRuns(dir string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, indexRunFilename+"*"))
+	for _, path := range matches {
+		_ = os.Remove(path)
+	}
+}
+
+func newDiskIndex(dir string, perm os.FileMode, cacheSize int64, memLimit int) *diskIndex {
+	return &diskIndex{
+		shared: &indexShared{
+			dir:   dir,
+			perm:  perm,
+			cache: newBlockCache(cacheSize),
+			refs:  make(map[*segment]uint32),
+		},
+		memLimit: memLimit,
+	}
+}
+
+func (s *indexShared) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failure == nil {
+		s.failure = err
+	}
+}
+
+func (s *indexShared) ref(seg *segment) uint32 {
+	if seg == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref, ok := s.refs[seg]
+	if !ok {
+		s.segments = append(s.segments, seg)
+		ref = uint32(len(s.segments))
+		s.refs[seg] = ref
+	}
+	return ref
+}
+
+func (s *indexShared) segment(ref uint32) *segment {
+	if ref == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.segments[ref-1]
+}
+
+func (d *diskIndex) len() int {
+	return d.size
+}
+
+func (d *diskIndex) err() error {
+	d.shared.mu.Lock()
+	defer d.shared.mu.Unlock()
+	return d.shared.failure
+}
+
+func (d *diskIndex) get(key string) (recordPos, bool) {
+	if pos, ok := d.mem.get(key); ok {
+		return pos, pos.seg != nil
+	}
+	for _, run := range d.runs {
+		pos, ok, err := d.shared.lookup(run, key)
+		if err != nil {
+			d.shared.fail(err)
+			return recordPos{}, false
+		}
+		if ok {
+			return pos, pos.seg != nil
+		}
+	}
+	return recordPos{}, false
+}
+
+func (d *diskIndex) set(key string, pos recordPos) {
+	if _, ok := d.get(key); !ok {
+		d.size++
+	}
+	d.mem.set(key, pos)
+	d.flushIfFull()
+}
+
+func (d *diskIndex) delete(key string) bool {
+	if _, ok := d.get(key); !ok {
+		return false
+	}
+	d.size--
+	if len(d.runs) == 0 {
+		d.mem.delete(key)
+	} else {
+		d.mem.set(key, recordPos{})
+	}
+	d.flushIfFull()
+	return true
+}
+
+func (d *diskIndex) ascend(start, end string) iter.Seq2[string, recordPos] {
+	return func(yield func(string, recordPos) bool) {
+		sources := []iter.Seq2[string, recordPos]{d.mem.ascend(start, end)}
+		for _, run := range d.runs {
+			sources = append(sources, d.shared.scan(run, start, end))
+		}
+		for key, pos := range mergeSources(sources) {
+			if pos.seg != nil && !yield(key, pos) {
+				return
+			}
+		}
+	}
+}
+
+func (d *diskIndex) view() index {
+	c := *d
+	c.runs = append([]*indexRun(nil), d.runs...)
+	d.shared.mu.Lock()
+	for _, run := range c.runs {
+		run.users++
+	}
+	d.shared.mu.Unlock()
+	return &c
+}
+
+func (d *diskIndex) release() {
+	d.shared.mu.Lock()
+	defer d.shared.mu.Unlock()
+	for _, run := range d.runs {
+		d.shared.unuse(run)
+	}
+	d.runs = nil
+}
+
+// unuse removes the run once no index reads it any more. Callers must hold
+// s.mu.
+func (s *indexShared) unuse(run *indexRun) {
+	run.users--
+	if run.users == 0 {
+		s.cache.drop(run)
+		_ = run.file.Close()
+		_ = os.Remove(run.file.Name())
+	}
+}
+
+// flushIfFull writes the recent changes out as a new run once there are
+// enough of them, which takes time in proportion to the memory limit only.
+func (d *diskIndex) flushIfFull() {
+	if d.mem.len() < d.memLimit {
+		return
+	}
+	run, err := d.shared.writeRun(d.mem.ascend("", ""), len(d.runs) == 0)
+	if err != nil {
+		d.shared.fail(err)
+		return
+	}
+	d.mem = treap{}
+	d.runs = append([]*indexRun{run}, d.runs...)
+	if d.deferMerges {
+		return
+	}
+	for i := d.mergeCandidate(); i >= 0; i = d.mergeCandidate() {
+		newer, older := d.runs[i], d.runs[i+1]
+		merged, err := d.shared.mergeRuns(newer, older, i+2 == len(d.runs))
+		if err != nil {
+			d.shared.fail(err)
+			return
+		}
+		d.replaceRuns(newer, older, merged)
+	}
+}
+
+// mergeCandidate returns the position of the newest run that is at least
+// half the size of the older one next to it, or -1 if there is none. Merging
+// such pairs keeps the sizes of the runs falling by half or more from the
+// oldest to the newest, so there are only a logarithmic number of them.
+func (d *diskIndex) mergeCandidate() int {
+	for i := 0; i+1 < len(d.runs); i++ {
+		if 2*d.runs[i].entries >= d.runs[i+1].entries {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeRuns writes a run that holds the contents of two neighbouring runs.
+// It only reads them, runs never change once written, so it can go on while
+// the index is used and changed.
+func (s *indexShared) mergeRuns(newer, older *indexRun, oldest bool) (*indexRun, error) {
+	return s.writeRun(mergeSources([]iter.Seq2[string, recordPos]{
+		s.scan(newer, "", ""),
+		s.scan(older, "", ""),
+	}), oldest)
+}
+
+// replaceRuns puts the merged run in place of the two runs it was made of.
+// New runs are only ever added in front and only merges remove runs, so
+// the pair is still there, next to each other.
+func (d *diskIndex) replaceRuns(newer, older, merged *indexRun) {
+	i := slices.Index(d.runs, newer)
+	d.runs = slices.Concat(d.runs[:i], []*indexRun{merged}, d.runs[i+2:])
+	d.shared.mu.Lock()
+	d.shared.unuse(newer)
+	d.shared.unuse(older)
+	d.shared.mu.Unlock()
+}
+
+// mergeIndexRuns merges the runs of a disk index that flushIfFull left for
+// later. db.mu is only held to pick a pair and to put the merged run in its
+// place, so lookups and writes go on while the merged run is written.
+// Callers must hold db.compactMu, compaction merges runs too.
+func (db *Database) mergeIndexRuns() {
+	d, ok := db.records.(*diskIndex)
+	if !ok {
+		return
+	}
+	for {
+		db.mu.RLock()
+		i := d.mergeCandidate()
+		var newer, older *indexRun
+		if i >= 0 {
+			newer, older = d.runs[i], d.runs[i+1]
+		}
+		// The oldest run stays the oldest, runs are only added in front.
+		oldest := i+2 == len(d.runs)
+		db.mu.RUnlock()
+		if i < 0 {
+			return
+		}
+
+		merged, err := d.shared.mergeRuns(newer, older, oldest)
+		if err != nil {
+			d.shared.fail(err)
+			return
+		}
+		db.mu.Lock()
+		d.replaceRuns(newer, older, merged)
+		db.mu.Unlock()
+	}
+}
+
+// mergeSources yields the keys of sorted sources in order. A key found in
+// several of them is taken from the first one.
+func mergeSources(sources []iter.Seq2[string, recordPos]) iter.Seq2[string, recordPos] {
+	return func(yield func(string, recordPos) bool) {
+		type head struct {
+			next  func() (string, recordPos, bool)
+			key   string
+			pos   recordPos
+			valid bool
+		}
+		heads := make([]*head, len(sources))
+		for i, src := range sources {
+			next, stop := iter.Pull2(src)
+			defer stop()
+			h := &head{next: next}
+			h.key, h.pos, h.valid = next()
+			heads[i] = h
+		}
+
+		for {
+			var first *head
+			for _, h := range heads {
+				if h.valid && (first == nil || h.key < first.key) {
+					first = h
+				}
+			}
+			if first == nil {
+				return
+			}
+			key, pos := first.key, first.pos
+			for _, h := range heads {
+				for h.valid && h.key == key {
+					h.key, h.pos, h.valid = h.next()
+				}
+			}
+			if !yield(key, pos) {
+				return
+			}
+		}
+	}
+}
+
+// writeRun writes the entries to a new run file. Deleted keys are left out
+// of the oldest run since there is nothing left for them to hide.
+func (s *indexShared) writeRun(entries iter.Seq2[string, recordPos], oldest bool) (*indexRun, error) {
+	s.mu.Lock()
+	id := s.nextRun
+	s.nextRun++
+	s.mu.Unlock()
+
+	path := filepath.Join(s.dir, indexRunFilename+strconv.Itoa(id))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.perm)
+	if err != nil {
+		return nil, err
+	}
+	run := &indexRun{id: id, file: f, users: 1}
+	fail := func(err error) (*indexRun, error) {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	var block []byte
+	var first string
+	var offset int64
+	flush := func() error {
+		if len(block) == 0 {
+			return nil
+		}
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+		run.blocks = append(run.blocks, runBlock{first, offset, len(block)})
+		offset += int64(len(block))
+		block = block[:0]
+		return nil
+	}
+
+	for key, pos := range entries {
+		if oldest && pos.seg == nil {
+			continue
+		}
+		if len(block) == 0 {
+			first = key
+		}
+		block = binary.LittleEndian.AppendUint32(block, uint32(len(key)))
+		block = binary.LittleEndian.AppendUint32(block, s.ref(pos.seg))
+		block = binary.LittleEndian.AppendUint64(block, uint64(pos.offset))
+		block = binary.LittleEndian.AppendUint64(block, uint64(pos.size))
+		block = binary.LittleEndian.AppendUint64(block, uint64(pos.expiresAt))
+		block = append(block, key...)
+		run.entries++
+		if len(block) >= indexBlockSize {
+			if err := flush(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return fail(err)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	return run, nil
+}
+
+var errBadRun = errors.New("bad index run")
+
+func (s *indexShared) readBlock(run *indexRun, i int) ([]runEntry, error) {
+	b := run.blocks[i]
+	buf := make([]byte, b.size)
+	if err := readFull(run.file, buf, b.offset); err != nil {
+		return nil, err
+	}
+	var entries []runEntry
+	for len(buf) > 0 {
+		if len(buf) < runEntrySize {
+			return nil, errBadRun
+		}
+		kLen := int(binary.LittleEndian.Uint32(buf[0:4]))
+		if len(buf) < runEntrySize+kLen {
+			return nil, errBadRun
+		}
+		entries = append(entries, runEntry{
+			key: string(buf[runEntrySize : runEntrySize+kLen]),
+			pos: recordPos{
+				seg:       s.segment(binary.LittleEndian.Uint32(buf[4:8])),
+				offset:    int64(binary.LittleEndian.Uint64(buf[8:16])),
+				size:      int64(binary.LittleEndian.Uint64(buf[16:24])),
+				expiresAt: int64(binary.LittleEndian.Uint64(buf[24:32])),
+			},
+		})
+		buf = buf[runEntrySize+kLen:]
+	}
+	return entries, nil
+}
+
+// lookup finds the key in a run through the block cache. A deleted key is
+// found with a position without a segment.
+func (s *indexShared) lookup(run *indexRun, key string) (recordPos, bool, error) {
+	i := sort.Search(len(run.blocks), func(i int) bool {
+		return run.blocks[i].first > key
+	}) - 1
+	if i < 0 {
+		return recordPos{}, false, nil
+	}
+
+	entries, ok := s.cache.get(run, i)
+	if !ok {
+		var err error
+		if entries, err = s.readBlock(run, i); err != nil {
+			return recordPos{}, false, err
+		}
+		s.cache.add(run, i, entries, run.blocks[i].size)
+	}
+	j := sort.Search(len(entries), func(j int) bool {
+		return entries[j].key >= key
+	})
+	if j < len(entries) && entries[j].key == key {
+		return entries[j].pos, true, nil
+	}
+	return recordPos{}, false, nil
+}
+
+// scan yields the entries of a run in [start, end), deleted keys included.
+// It reads past the cache, so long scans do not evict the blocks that
+// lookups need.
+func (s *indexShared) scan(run *indexRun, start, end string) iter.Seq2[string, recordPos] {
+	return func(yield func(string, recordPos) bool) {
+		i := max(sort.Search(len(run.blocks), func(i int) bool {
+			return run.blocks[i].first > start
+		})-1, 0)
+		for ; i < len(run.blocks); i++ {
+			entries, err := s.readBlock(run, i)
+			if err != nil {
+				s.fail(err)
+				return
+			}
+			for _, e := range entries {
+				if e.key < start {
+					continue
+				}
+				if end != "" && e.key >= end {
+					return
+				}
+				if !yield(e.key, e.pos) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// blockCache keeps recently read run blocks, up to a number of bytes of
+// their encoded size.
+type blockCache struct {
+	capacity int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[blockID]*list.Element
+}
+
+type blockID struct {
+	run   *indexRun
+	block int
+}
+
+type cachedBlock struct {
+	id      blockID
+	entries []runEntry
+	size    int64
+}
+
+func newBlockCache(capacity int64) *blockCache {
+	return &blockCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[blockID]*list.Element),
+	}
+}
+
+func (c *blockCache) get(run *indexRun, block int) ([]runEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[blockID{run, block}]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cachedBlock).entries, true
+}
+
+func (c *blockCache) add(run *indexRun, block int, entries []runEntry, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := blockID{run, block}
+	if _, ok := c.entries[id]; ok || int64(size) > c.capacity {
+		return
+	}
+	c.entries[id] = c.order.PushFront(&cachedBlock{id, entries, int64(size)})
+	c.size += int64(size)
+	for c.size > c.capacity {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+// drop forgets the blocks of a run that is being removed.
+func (c *blockCache) drop(run *indexRun) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range run.blocks {
+		if el, ok := c.entries[blockID{run, i}]; ok {
+			c.removeLocked(el)
+		}
+	}
+}
+
+func (c *blockCache) removeLocked(el *list.Element) {
+	b := el.Value.(*cachedBlock)
+	c.size -= b.size
+	c.order.Remove(el)
+	delete(c.entries, b.id)
+}
@@ -0,0 +1,180 @@
+package datastore
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDiskIndex(t *testing.T) {
+	dir := t.TempDir()
+	d := newDiskIndex(dir, 0o600, 4096, 16)
+	segs := []*segment{{id: 0}, {id: 1}}
+
+	expected := make(map[string]recordPos)
+	var frozen index
+	var frozenKeys []string
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key%04d", rnd.Intn(800))
+		if rnd.Intn(4) == 0 {
+			_, had := expected[key]
+			if d.delete(key) != had {
+				t.Fatalf("delete(%q) disagrees with the reference map", key)
+			}
+			delete(expected, key)
+		} else {
+			pos := recordPos{seg: segs[i%2], offset: int64(i), size: 10}
+			d.set(key, pos)
+			expected[key] = pos
+		}
+
+		if i == 1500 {
+			frozen = d.view()
+			for key := range expected {
+				frozenKeys = append(frozenKeys, key)
+			}
+			slices.Sort(frozenKeys)
+		}
+	}
+	if err := d.err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.runs) == 0 {
+		t.Fatal("Expected the index to be written to runs")
+	}
+
+	if d.len() != len(expected) {
+		t.Errorf("len() = %d, wanted %d", d.len(), len(expected))
+	}
+	for key, pos := range expected {
+		if got, ok := d.get(key); !ok || got != pos {
+			t.Errorf("get(%q) = %+v, %t, wanted %+v", key, got, ok, pos)
+		}
+	}
+	var keys []string
+	for key, pos := range d.ascend("", "") {
+		if pos != expected[key] {
+			t.Errorf("Position of %q is %+v, wanted %+v", key, pos, expected[key])
+		}
+		keys = append(keys, key)
+	}
+	if !slices.IsSorted(keys) || len(keys) != len(expected) {
+		t.Errorf("ascend does not yield every key in order")
+	}
+
+	var seen []string
+	for key := range frozen.ascend("", "") {
+		seen = append(seen, key)
+	}
+	if !slices.Equal(seen, frozenKeys) {
+		t.Errorf("The view sees %d keys, wanted the %d it was made with", len(seen), len(frozenKeys))
+	}
+
+	frozen.release()
+	d.release()
+	if matches, _ := filepath.Glob(filepath.Join(dir, indexRunFilename+"*")); len(matches) != 0 {
+		t.Errorf("Expected released runs to be removed, found %v", matches)
+	}
+}
+
+func TestDiskIndexDeferredMerges(t *testing.T) {
+	d := newDiskIndex(t.TempDir(), 0o600, 4096, 16)
+	d.deferMerges = true
+	db := &Database{records: d}
+	t.Cleanup(d.release)
+	seg := &segment{id: 0}
+
+	for i := 0; i < 1600; i++ {
+		d.set(fmt.Sprintf("key%04d", i%1000), recordPos{seg: seg, offset: int64(i), size: 10})
+	}
+	if len(d.runs) != 100 {
+		t.Fatalf("Expected a run for every flush and no merges, got %d runs", len(d.runs))
+	}
+
+	db.compactMu.Lock()
+	db.mergeIndexRuns()
+	db.compactMu.Unlock()
+	if err := d.err(); err != nil {
+		t.Fatal(err)
+	}
+	if i := d.mergeCandidate(); i >= 0 || len(d.runs) > 8 {
+		t.Errorf("%d runs are left, run %d can still be merged", len(d.runs), i)
+	}
+	if d.len() != 1000 {
+		t.Errorf("len() = %d, wanted 1000", d.len())
+	}
+	for i := 600; i < 1600; i++ {
+		key := fmt.Sprintf("key%04d", i%1000)
+		if pos, ok := d.get(key); !ok || pos.offset != int64(i) {
+			t.Errorf("get(%q) = %+v, %t, wanted offset %d", key, pos, ok, i)
+		}
+	}
+}
+
+func TestDbDiskIndex(t *testing.T) {
+	tmp := t.TempDir()
+	reopen := func() *Database {
+		db, err := OpenWithOptions(tmp, WithSegmentSize(1024), WithCompaction(CompactionConfig{}), WithDiskIndex(8192), func(o *options) {
+			o.indexMemLimit = 8
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	db := reopen()
+
+	expected := make(map[string]string)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("key%03d", i%120)
+		expected[key] = fmt.Sprintf("value-%d", i)
+		if err := db.Put(key, expected[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 120; i += 7 {
+		key := fmt.Sprintf("key%03d", i)
+		if err := db.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+		delete(expected, key)
+	}
+
+	snapshot := db.Snapshot()
+	if err := db.Put("key001", "after snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := snapshot.Get("key001"); err != nil || value != expected["key001"] {
+		t.Errorf("Snapshot Get(key001) = %q, %v", value, err)
+	}
+	snapshot.Release()
+	expected["key001"] = "after snapshot"
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check := func(db *Database) {
+		t.Helper()
+		for key, value := range expected {
+			if got, err := db.Get(key); err != nil || got != value {
+				t.Errorf("Get(%q) = %q, %v, wanted %q", key, got, err, value)
+			}
+		}
+		if keys := db.Keys(""); len(keys) != len(expected) {
+			t.Errorf("Keys() returned %d keys, wanted %d", len(keys), len(expected))
+		}
+	}
+	check(db)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopen()
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	check(db)
+}
@@ -0,0 +1,57 @@
+package datastore
+
+import (
+	"iter"
+	"time"
+)
+
+// Engine is a key-value store with the operations the db server needs.
+// Database is the log-structured implementation, MemoryEngine keeps the data
+// in memory only.
+type Engine interface {
+	Get(key string) (string, error)
+	GetValue(key string) (Value, error)
+	// GetWithVersion also returns a number that changes with every write to
+	// the key.
+	GetWithVersion(key string) (Value, uint64, error)
+	Put(key, value string) error
+	PutValue(key string, value Value) error
+	PutValueTTL(key string, value Value, ttl time.Duration) error
+	// ExpiresAt returns the zero time for a key that never expires.
+	ExpiresAt(key string) (time.Time, error)
+	// Delete returns ErrKeyMissing if there is nothing to delete.
+	Delete(key string) error
+	// Increment, CompareAndSwap and CompareVersionAndSwap keep the TTL the
+	// key had.
+	Increment(key string, delta int64) (int64, error)
+	CompareAndSwap(key string, old, next Value) error
+	CompareVersionAndSwap(key string, version uint64, next Value) error
+	NewBatch() *Batch
+	Scan(start, end string) iter.Seq2[string, Value]
+	Stats() (Stats, error)
+	Close() error
+}
+
+// Stats describes the contents of an engine.
+type Stats struct {
+	// Keys is the number of stored keys, expired ones that were not swept
+	// yet included.
+	Keys int
+	// Size is the number of bytes the data takes, on disk for Database.
+	Size int64
+}
+
+var (
+	_ Engine = (*Database)(nil)
+	_ Engine = (*MemoryEngine)(nil)
+)
+
+func (db *Database) Stats() (Stats, error) {
+	size, err := db.Size()
+	if err != nil {
+		return Stats{}, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return Stats{Keys: db.records.len(), Size: size}, nil
+}
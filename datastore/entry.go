@@ -2,63 +2,408 @@ package datastore
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"iter"
 	"os"
+	"time"
+)
+
+// Segment files start with a magic string followed by the format version.
+// Files written before the header was introduced have no header at all and
+// are read as formatLegacy.
+const (
+	segmentMagic      = "KVS"
+	segmentHeaderSize = 4
+
+	formatLegacy  byte = 0
+	formatV1      byte = 1
+	formatV2      byte = 2
+	currentFormat      = formatV2
+)
+
+const (
+	// entryHeaderSize covers the checksum, flags and lengths. Since
+	// formatV2 the sequence number follows them.
+	entryHeaderSize = 13
+	seqSize         = 8
+	maxEntrySize    = 1 << 30
+)
+
+// headerSize returns the size of the fixed part of a record in the given
+// format.
+func headerSize(version byte) int64 {
+	if version >= formatV2 {
+		return entryHeaderSize + seqSize
+	}
+	return entryHeaderSize
+}
+
+var ErrCorrupted = errors.New("record is corrupted")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+const (
+	flagTombstone byte = 1 << 0
+	// flagBatch marks a record that is followed by more records of the same
+	// batch. The last record of a batch does not have it.
+	flagBatch byte = 1 << 1
+
+	// The value type takes two bits of the flags. Records written before
+	// typed values existed have zero there and hold strings.
+	flagTypeShift      = 2
+	flagTypeMask  byte = 0b11 << flagTypeShift
+
+	// flagExpiry tells that the key is followed by an expiry timestamp.
+	flagExpiry byte = 1 << 4
+	expirySize      = 8
 )
 
 type kvPair struct {
 	key   string
 	value string
+	kind  ValueType
+	// seq orders the records of a database. Zero means that the writer has
+	// to assign the next one, records written before formatV2 keep zero.
+	seq uint64
+	// expiresAt is a Unix time in nanoseconds after which the record no
+	// longer counts, zero if it never expires.
+	expiresAt int64
+	// deleted marks a tombstone that removes the key.
+	deleted bool
+}
+
+func (p kvPair) expired(now int64) bool {
+	return p.expiresAt != 0 && now >= p.expiresAt
+}
+
+func (p kvPair) Key() string {
+	return p.key
+}
+
+func (p kvPair) Value() Value {
+	return Value{p.kind, p.value}
+}
+
+// Seq returns the sequence number of the record, zero for records written
+// before sequence numbers existed.
+func (p kvPair) Seq() uint64 {
+	return p.seq
+}
+
+// Deleted tells whether the record is a tombstone.
+func (p kvPair) Deleted() bool {
+	return p.deleted
+}
+
+// ExpiresAt returns the expiry time of the record, zero if it has none.
+func (p kvPair) ExpiresAt() time.Time {
+	if p.expiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, p.expiresAt)
 }
 
+// Serialize encodes the pair in the current record format:
+//
+//	crc32 | flags | key length | value length | seq | [expiry] | key | value
+//
+// The checksum covers everything that follows it. The expiry is only present
+// when flagExpiry is set. Serialize never compresses the value, but records
+// read back may have been written with flagCompressed.
 func Serialize(pair kvPair) []byte {
+	return encode(pair, 0)
+}
+
+func encode(pair kvPair, flags byte) []byte {
 	kLen := len(pair.key)
 	vLen := len(pair.value)
-	buf := make([]byte, kLen+vLen+8)
+	start := int(headerSize(currentFormat))
+	if pair.expiresAt != 0 {
+		flags |= flagExpiry
+		start += expirySize
+	}
+	buf := make([]byte, start+kLen+vLen)
 
-	binary.LittleEndian.PutUint32(buf[0:4], uint32(kLen))
-	copy(buf[4:], pair.key)
-	binary.LittleEndian.PutUint32(buf[4+kLen:8+kLen], uint32(vLen))
-	copy(buf[8+kLen:], pair.value)
+	if pair.deleted {
+		flags |= flagTombstone
+	}
+	flags |= byte(pair.kind) << flagTypeShift & flagTypeMask
+	buf[4] = flags
+	binary.LittleEndian.PutUint32(buf[5:9], uint32(kLen))
+	binary.LittleEndian.PutUint32(buf[9:13], uint32(vLen))
+	binary.LittleEndian.PutUint64(buf[entryHeaderSize:], pair.seq)
+	if pair.expiresAt != 0 {
+		binary.LittleEndian.PutUint64(buf[start-expirySize:], uint64(pair.expiresAt))
+	}
+	copy(buf[start:], pair.key)
+	copy(buf[start+kLen:], pair.value)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.Checksum(buf[4:], crcTable))
 
 	return buf
 }
 
+// readFull reads exactly len(buf) bytes at offset. It returns io.EOF only
+// when there is nothing at all to read, and io.ErrUnexpectedEOF when the
+// data ends halfway.
+func readFull(r io.ReaderAt, buf []byte, offset int64) error {
+	n, err := r.ReadAt(buf, offset)
+	if n == len(buf) {
+		return nil
+	}
+	if err == io.EOF && n > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
 func readString(r io.ReaderAt, offset int64) (string, int, error) {
 	header := make([]byte, 4)
-	if _, err := r.ReadAt(header, offset); err != nil {
+	if err := readFull(r, header, offset); err != nil {
 		return "", 0, err
 	}
 	length := int(binary.LittleEndian.Uint32(header))
+	if length > maxEntrySize {
+		return "", 0, ErrCorrupted
+	}
 	content := make([]byte, length)
-	if _, err := r.ReadAt(content, offset+4); err != nil {
+	if err := readFull(r, content, offset+4); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return "", 0, err
 	}
 	return string(content), 4 + length, nil
 }
 
+// LoadEntry reads a record in the current format.
 func LoadEntry(r io.ReaderAt, offset int64) (kvPair, error) {
-	k, kSize, err := readString(r, offset)
+	pair, _, err := loadEntry(r, offset, currentFormat)
+	return pair, err
+}
+
+// loadEntry reads a record written in the given format version and returns
+// it together with its encoded size.
+func loadEntry(r io.ReaderAt, offset int64, version byte) (kvPair, int64, error) {
+	pair, _, size, err := loadRecord(r, offset, version)
+	return pair, size, err
+}
+
+// loadRecord is loadEntry that also returns the record flags.
+func loadRecord(r io.ReaderAt, offset int64, version byte) (kvPair, byte, int64, error) {
+	if version == formatLegacy {
+		pair, size, err := loadLegacyEntry(r, offset)
+		return pair, 0, size, err
+	}
+
+	fixed := headerSize(version)
+	header := make([]byte, fixed)
+	if err := readFull(r, header, offset); err != nil {
+		return kvPair{}, 0, 0, err
+	}
+	size, err := recordSize(header, version)
 	if err != nil {
+		return kvPair{}, 0, 0, err
+	}
+
+	buf := make([]byte, size)
+	copy(buf, header)
+	if err := readFull(r, buf[fixed:], offset+fixed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return kvPair{}, 0, 0, err
+	}
+	pair, err := decodeRecord(buf, version)
+	return pair, buf[4], size, err
+}
+
+// recordSize returns the encoded size of the record that starts with the
+// given header.
+func recordSize(header []byte, version byte) (int64, error) {
+	kLen := int64(binary.LittleEndian.Uint32(header[5:9]))
+	vLen := int64(binary.LittleEndian.Uint32(header[9:13]))
+	if kLen+vLen > maxEntrySize {
+		return 0, ErrCorrupted
+	}
+	size := headerSize(version) + kLen + vLen
+	if header[4]&flagExpiry != 0 {
+		size += expirySize
+	}
+	return size, nil
+}
+
+// decodeRecord parses a whole record held in buf.
+func decodeRecord(buf []byte, version byte) (kvPair, error) {
+	fixed := headerSize(version)
+	if int64(len(buf)) < fixed {
+		return kvPair{}, ErrCorrupted
+	}
+	if size, err := recordSize(buf, version); err != nil || size != int64(len(buf)) {
+		return kvPair{}, ErrCorrupted
+	}
+	if crc32.Checksum(buf[4:], crcTable) != binary.LittleEndian.Uint32(buf[0:4]) {
+		return kvPair{}, ErrCorrupted
+	}
+
+	flags := buf[4]
+	kLen := int64(binary.LittleEndian.Uint32(buf[5:9]))
+	start := fixed
+	if flags&flagExpiry != 0 {
+		start += expirySize
+	}
+	body := buf[start:]
+	pair := kvPair{
+		key:     string(body[:kLen]),
+		value:   string(body[kLen:]),
+		kind:    ValueType(flags & flagTypeMask >> flagTypeShift),
+		deleted: flags&flagTombstone != 0,
+	}
+	if flags&flagCompressed != 0 {
+		value, err := inflate(body[kLen:])
+		if err != nil {
+			return kvPair{}, err
+		}
+		pair.value = value
+	}
+	if version >= formatV2 {
+		pair.seq = binary.LittleEndian.Uint64(buf[entryHeaderSize:])
+	}
+	if flags&flagExpiry != 0 {
+		pair.expiresAt = int64(binary.LittleEndian.Uint64(buf[fixed:]))
+	}
+	return pair, nil
+}
+
+// readAt reads the record at pos with a single ReadAt of the size stored in
+// the index, through the handle the segment keeps open.
+func readAt(pos recordPos) (kvPair, error) {
+	if pos.seg.version == formatLegacy {
+		e, _, err := loadEntry(pos.seg.file, pos.offset, formatLegacy)
+		return e, err
+	}
+	buf := make([]byte, pos.size)
+	if err := readFull(pos.seg.file, buf, pos.offset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return kvPair{}, err
 	}
-	v, _, err := readString(r, offset+int64(kSize))
+	return decodeRecord(buf, pos.seg.version)
+}
+
+func loadLegacyEntry(r io.ReaderAt, offset int64) (kvPair, int64, error) {
+	k, kSize, err := readString(r, offset)
 	if err != nil {
-		return kvPair{}, err
+		return kvPair{}, 0, err
 	}
-	return kvPair{k, v}, nil
+	v, vSize, err := readString(r, offset+int64(kSize))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return kvPair{}, 0, err
+	}
+	return kvPair{key: k, value: v}, int64(kSize + vSize), nil
 }
 
-func Stream(f *os.File) iter.Seq[kvPair] {
-	return func(yield func(kvPair) bool) {
-		var offset int64
+func writeSegmentHeader(w io.WriterAt) error {
+	_, err := w.WriteAt(append([]byte(segmentMagic), currentFormat), 0)
+	return err
+}
+
+// readSegmentHeader returns the format version of a segment and the offset
+// of its first record.
+func readSegmentHeader(r io.ReaderAt) (byte, int64, error) {
+	header := make([]byte, segmentHeaderSize)
+	n, err := r.ReadAt(header, 0)
+	if n < segmentHeaderSize && err != io.EOF {
+		return 0, 0, err
+	}
+	if n < segmentHeaderSize || string(header[:len(segmentMagic)]) != segmentMagic {
+		return formatLegacy, 0, nil
+	}
+
+	version := header[len(segmentMagic)]
+	if version > currentFormat {
+		return 0, 0, fmt.Errorf("unsupported segment format %d", version)
+	}
+	return version, segmentHeaderSize, nil
+}
+
+// scanner walks the records of a segment file one after another.
+type scanner struct {
+	r       io.ReaderAt
+	version byte
+	// end is the offset right after the last record read so far.
+	end int64
+	// err tells why the scan stopped. It stays nil at a clean end of data.
+	err error
+}
+
+// isDamagedTail tells whether a scan stopped because of a torn or corrupted
+// record rather than an I/O failure.
+func isDamagedTail(err error) bool {
+	return err == io.ErrUnexpectedEOF || err == ErrCorrupted
+}
+
+func newScanner(r io.ReaderAt) (*scanner, error) {
+	version, start, err := readSegmentHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &scanner{r: r, version: version, end: start}, nil
+}
+
+// entries yields the position of every record together with its contents.
+// The returned positions have no segment set. Records of a batch are only
+// yielded once the whole batch has been read, and a batch that is cut short
+// is reported as a torn tail.
+func (s *scanner) entries() iter.Seq2[recordPos, kvPair] {
+	return func(yield func(recordPos, kvPair) bool) {
+		type pending struct {
+			pos  recordPos
+			pair kvPair
+		}
+		var batch []pending
+
+		next := s.end
 		for {
-			pair, err := LoadEntry(f, offset)
+			pair, flags, size, err := loadRecord(s.r, next, s.version)
 			if err != nil {
+				if err != io.EOF {
+					s.err = err
+				} else if len(batch) > 0 {
+					s.err = io.ErrUnexpectedEOF
+				}
 				return
 			}
-			offset += int64(len(pair.key)+len(pair.value) + 8)
+			batch = append(batch, pending{recordPos{offset: next, size: size}, pair})
+			next += size
+			if flags&flagBatch != 0 {
+				continue
+			}
+
+			s.end = next
+			for _, p := range batch {
+				if !yield(p.pos, p.pair) {
+					return
+				}
+			}
+			batch = batch[:0]
+		}
+	}
+}
+
+func Stream(f *os.File) iter.Seq[kvPair] {
+	return func(yield func(kvPair) bool) {
+		sc, err := newScanner(f)
+		if err != nil {
+			return
+		}
+		for _, pair := range sc.entries() {
 			if !yield(pair) {
 				return
 			}
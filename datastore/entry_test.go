@@ -2,11 +2,14 @@ package datastore
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
 	"testing"
 )
 
 func TestSerializeDeserialize(t *testing.T) {
-	input := kvPair{"key", "value"}
+	input := kvPair{key: "key", value: "value"}
 	data := Serialize(input)
 	result, err := LoadEntry(bytes.NewReader(data), 0)
 	if err != nil {
@@ -19,3 +22,57 @@ func TestSerializeDeserialize(t *testing.T) {
 		t.Errorf("expected value %q, got %q", input.value, result.value)
 	}
 }
+
+func TestLoadEntryCorrupted(t *testing.T) {
+	data := Serialize(kvPair{key: "key", value: "value"})
+	data[len(data)-1] ^= 0xff
+	if _, err := LoadEntry(bytes.NewReader(data), 0); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, got %v", err)
+	}
+
+	data = Serialize(kvPair{key: "key", value: "value"})
+	if _, err := LoadEntry(bytes.NewReader(data[:len(data)-2]), 0); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF for a torn record, got %v", err)
+	}
+}
+
+func TestLoadLegacyEntry(t *testing.T) {
+	var data []byte
+	data = binary.LittleEndian.AppendUint32(data, 3)
+	data = append(data, "key"...)
+	data = binary.LittleEndian.AppendUint32(data, 5)
+	data = append(data, "value"...)
+
+	result, size, err := loadEntry(bytes.NewReader(data), 0, formatLegacy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.key != "key" || result.value != "value" {
+		t.Errorf("unexpected entry %+v", result)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("expected size %d, got %d", len(data), size)
+	}
+}
+
+func TestSerializeTombstone(t *testing.T) {
+	data := Serialize(kvPair{key: "key", deleted: true})
+	result, err := LoadEntry(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.key != "key" || !result.deleted {
+		t.Errorf("expected a tombstone for %q, got %+v", "key", result)
+	}
+}
+
+func TestSerializeValueType(t *testing.T) {
+	data := Serialize(Int64Value(7).pair("key"))
+	result, err := LoadEntry(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.kind != TypeInt64 {
+		t.Errorf("expected type %s, got %s", TypeInt64, result.kind)
+	}
+}
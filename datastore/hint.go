@@ -0,0 +1,310 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A hint file sits next to a sealed segment and lists the positions of its
+// records without the values, so Open can rebuild the index without reading
+// the whole segment:
+//
+//	magic | version | segment size | valid end | max seq | entries... | crc32
+//
+// Every entry is encoded as flags | key length | offset | size | expiry | key.
+// The checksum covers the whole file. The segment size lets Open notice a
+// hint that does not describe the current segment contents. The maximum
+// sequence number outlives the records compaction drops, so Open never hands
+// out a number twice.
+const (
+	hintSuffix      = ".hint"
+	hintMagic       = "KVH"
+	hintFormat byte = 3
+
+	hintHeaderSize = len(hintMagic) + 1 + 24
+	hintEntrySize  = 1 + 4 + 8 + 8 + 8
+)
+
+var errBadHint = errors.New("bad hint file")
+
+type hintEntry struct {
+	key       string
+	offset    int64
+	size      int64
+	expiresAt int64
+	deleted   bool
+}
+
+func (db *Database) hintPath(id int) string {
+	return db.segmentPath(id) + hintSuffix
+}
+
+func appendHintHeader(buf []byte, fileSize, end int64, maxSeq uint64) []byte {
+	buf = append(buf, hintMagic...)
+	buf = append(buf, hintFormat)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(fileSize))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(end))
+	return binary.LittleEndian.AppendUint64(buf, maxSeq)
+}
+
+func appendHintEntry(buf []byte, e hintEntry) []byte {
+	var flags byte
+	if e.deleted {
+		flags |= flagTombstone
+	}
+	buf = append(buf, flags)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(e.key)))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(e.offset))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(e.size))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(e.expiresAt))
+	return append(buf, e.key...)
+}
+
+// hintHeader is what the header of a hint file holds.
+type hintHeader struct {
+	fileSize int64
+	end      int64
+	maxSeq   uint64
+}
+
+// openHint checks the header and the checksum of a hint file and returns a
+// reader of its entries. The file is read twice, once for the checksum and
+// once for the entries, so it never has to fit in memory.
+func openHint(f *os.File) (hintHeader, *bufio.Reader, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return hintHeader{}, nil, err
+	}
+	size := info.Size() - 4
+	if size < int64(hintHeaderSize) {
+		return hintHeader{}, nil, errBadHint
+	}
+	sum := crc32.New(crcTable)
+	if _, err := io.Copy(sum, io.NewSectionReader(f, 0, size)); err != nil {
+		return hintHeader{}, nil, err
+	}
+	var buf [hintHeaderSize + 4]byte
+	if err := readFull(f, buf[:4], size); err != nil {
+		return hintHeader{}, nil, err
+	}
+	if sum.Sum32() != binary.LittleEndian.Uint32(buf[:4]) {
+		return hintHeader{}, nil, errBadHint
+	}
+	header := buf[:hintHeaderSize]
+	if err := readFull(f, header, 0); err != nil {
+		return hintHeader{}, nil, err
+	}
+	if string(header[:len(hintMagic)]) != hintMagic || header[len(hintMagic)] != hintFormat {
+		return hintHeader{}, nil, errBadHint
+	}
+
+	h := hintHeader{
+		fileSize: int64(binary.LittleEndian.Uint64(header[4:12])),
+		end:      int64(binary.LittleEndian.Uint64(header[12:20])),
+		maxSeq:   binary.LittleEndian.Uint64(header[20:28]),
+	}
+	return h, bufio.NewReader(io.NewSectionReader(f, int64(hintHeaderSize), size-int64(hintHeaderSize))), nil
+}
+
+// readHintEntry decodes the next entry. It returns io.EOF only if there is
+// no entry left at all.
+func readHintEntry(r *bufio.Reader) (hintEntry, error) {
+	var fixed [hintEntrySize]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return hintEntry{}, err
+	}
+	kLen := int(binary.LittleEndian.Uint32(fixed[1:5]))
+	if kLen > maxEntrySize {
+		return hintEntry{}, errBadHint
+	}
+	key := make([]byte, kLen)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return hintEntry{}, io.ErrUnexpectedEOF
+	}
+	return hintEntry{
+		key:       string(key),
+		offset:    int64(binary.LittleEndian.Uint64(fixed[5:13])),
+		size:      int64(binary.LittleEndian.Uint64(fixed[13:21])),
+		expiresAt: int64(binary.LittleEndian.Uint64(fixed[21:29])),
+		deleted:   fixed[0]&flagTombstone != 0,
+	}, nil
+}
+
+// hintWriter streams the entries of a hint file to disk, so the hint of a
+// large merged segment is never held in memory.
+type hintWriter struct {
+	f   *os.File
+	w   *bufio.Writer
+	buf []byte
+	n   int64
+}
+
+func (db *Database) createHint(id int) (*hintWriter, error) {
+	return newHintWriter(db.hintPath(id), db.opts.fileMode)
+}
+
+// newHintWriter writes the hint to a temporary file that finish puts in
+// place of path.
+func newHintWriter(path string, perm os.FileMode) (*hintWriter, error) {
+	f, err := os.OpenFile(path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+	// The header is only known at the end, finish fills it in.
+	hw := &hintWriter{f: f, w: bufio.NewWriter(f), n: int64(hintHeaderSize)}
+	_, err = hw.w.Write(make([]byte, hintHeaderSize))
+	if err != nil {
+		hw.abort()
+		return nil, err
+	}
+	return hw, nil
+}
+
+func (hw *hintWriter) add(e hintEntry) error {
+	hw.buf = appendHintEntry(hw.buf[:0], e)
+	hw.n += int64(len(hw.buf))
+	_, err := hw.w.Write(hw.buf)
+	return err
+}
+
+// finish writes the header and the checksum and puts the file in place of the
+// hint of the segment.
+func (hw *hintWriter) finish(fileSize, end int64, maxSeq uint64) error {
+	err := hw.w.Flush()
+	if err == nil {
+		_, err = hw.f.WriteAt(appendHintHeader(nil, fileSize, end, maxSeq), 0)
+	}
+	sum := crc32.New(crcTable)
+	if err == nil {
+		_, err = io.Copy(sum, io.NewSectionReader(hw.f, 0, hw.n))
+	}
+	if err == nil {
+		_, err = hw.f.WriteAt(binary.LittleEndian.AppendUint32(nil, sum.Sum32()), hw.n)
+	}
+	if err == nil {
+		err = hw.f.Close()
+	}
+	if err != nil {
+		hw.abort()
+		return err
+	}
+	tmp := hw.f.Name()
+	if err := os.Rename(tmp, strings.TrimSuffix(tmp, ".tmp")); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (hw *hintWriter) abort() {
+	_ = hw.f.Close()
+	_ = os.Remove(hw.f.Name())
+}
+
+// restoreFromHint replays the hint file of a sealed segment into the index.
+// It reports false if there is no usable hint and the segment has to be
+// scanned instead. A hint that turns out to be damaged after its checksum
+// matched fails the whole Open, the index may already hold part of it.
+func (db *Database) restoreFromHint(seg *segment, fileSize int64) (bool, error) {
+	f, err := os.Open(db.hintPath(seg.id))
+	if err != nil {
+		return false, nil
+	}
+	defer f.Close()
+	h, r, err := openHint(f)
+	if err != nil || h.fileSize != fileSize || h.end > fileSize {
+		return false, nil
+	}
+
+	for {
+		e, err := readHintEntry(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		pair := kvPair{key: e.key, expiresAt: e.expiresAt, deleted: e.deleted}
+		db.setRecord(pair, recordPos{seg: seg, offset: e.offset, size: e.size})
+	}
+	seg.garbage += fileSize - h.end
+	seg.end = h.end
+	seg.maxSeq = h.maxSeq
+	db.seq = max(db.seq, h.maxSeq)
+	seg.hinted = true
+	return true, nil
+}
+
+// hintSegment scans a sealed segment and writes its hint file.
+func (db *Database) hintSegment(seg *segment) error {
+	info, err := seg.file.Stat()
+	if err != nil {
+		return err
+	}
+	sc, err := newScanner(seg.file)
+	if err != nil {
+		return err
+	}
+	hint, err := db.createHint(seg.id)
+	if err != nil {
+		return err
+	}
+	maxSeq := seg.maxSeq
+	for pos, item := range sc.entries() {
+		if err := hint.add(hintEntry{item.key, pos.offset, pos.size, item.expiresAt, item.deleted}); err != nil {
+			hint.abort()
+			return err
+		}
+		maxSeq = max(maxSeq, item.seq)
+	}
+	if sc.err != nil && !isDamagedTail(sc.err) {
+		hint.abort()
+		return sc.err
+	}
+	if err := hint.finish(info.Size(), sc.end, maxSeq); err != nil {
+		return err
+	}
+	seg.hinted = true
+	return nil
+}
+
+// writeMissingHints writes hint files for sealed segments that have none yet.
+// Callers must hold db.compactMu.
+func (db *Database) writeMissingHints() error {
+	db.mu.RLock()
+	sealed := db.files[:len(db.files)-1]
+	var pending []*segment
+	for _, seg := range sealed {
+		if !seg.hinted {
+			pending = append(pending, seg)
+		}
+	}
+	db.mu.RUnlock()
+
+	for _, seg := range pending {
+		if err := db.hintSegment(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeOrphanHints deletes hint files whose segment no longer exists.
+func removeOrphanHints(dir string, ids []int) {
+	matches, _ := filepath.Glob(filepath.Join(dir, baseFilename+"*"+hintSuffix+"*"))
+	for _, path := range matches {
+		name := strings.TrimPrefix(filepath.Base(path), baseFilename)
+		id, err := strconv.Atoi(strings.TrimSuffix(name, hintSuffix))
+		if err != nil || !slices.Contains(ids, id) {
+			_ = os.Remove(path)
+		}
+	}
+}
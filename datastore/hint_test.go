@@ -0,0 +1,164 @@
+package datastore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHintEncoding(t *testing.T) {
+	entries := []hintEntry{
+		{key: "k1", offset: 4, size: 20},
+		{key: "k2", offset: 24, size: 30, expiresAt: 1700000000000000000},
+		{key: "k1", offset: 54, size: 15, deleted: true},
+	}
+	path := filepath.Join(t.TempDir(), "hint")
+	hw, err := newHintWriter(path, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := hw.add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hw.finish(100, 69, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() (hintHeader, []hintEntry, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		h, r, err := openHint(f)
+		if err != nil {
+			return h, nil, err
+		}
+		var decoded []hintEntry
+		for {
+			e, err := readHintEntry(r)
+			if err == io.EOF {
+				return h, decoded, nil
+			}
+			if err != nil {
+				return h, nil, err
+			}
+			decoded = append(decoded, e)
+		}
+	}
+	h, decoded, err := read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != (hintHeader{100, 69, 7}) || !reflect.DeepEqual(decoded, entries) {
+		t.Errorf("Unexpected hint contents %+v %+v", h, decoded)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[10] ^= 0xff
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := read(); err == nil {
+		t.Error("Expected a corrupted hint to be rejected")
+	}
+}
+
+func TestHintFiles(t *testing.T) {
+	tmp := t.TempDir()
+	db := openSmall(t, tmp, CompactionConfig{})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	value := strings.Repeat("v", 100)
+	expected := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("key%d", i%15)
+		expected[key] = fmt.Sprintf("%s-%d", value, i)
+		if err := db.Put(key, expected[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("key0"); err != nil {
+		t.Fatal(err)
+	}
+	delete(expected, "key0")
+
+	db.mu.RLock()
+	sealed := append([]*segment(nil), db.files[:len(db.files)-1]...)
+	db.mu.RUnlock()
+	if len(sealed) == 0 {
+		t.Fatal("Expected sealed segments")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, seg := range sealed {
+		for {
+			if _, err := os.Stat(db.hintPath(seg.id)); err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("No hint file for sealed segment %d", seg.id)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	check := func() {
+		t.Helper()
+		for key, want := range expected {
+			if got, err := db.Get(key); err != nil || got != want {
+				t.Errorf("Get(%q) = %q, %v, wanted %q", key, got, err, want)
+			}
+		}
+		if _, err := db.Get("key0"); err != ErrKeyMissing {
+			t.Errorf("Expected key0 to stay deleted, got %v", err)
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = openSmall(t, tmp, CompactionConfig{})
+	for _, seg := range db.files[:len(db.files)-1] {
+		if !seg.hinted {
+			t.Errorf("Segment %d was not loaded from its hint file", seg.id)
+		}
+	}
+	check()
+
+	// A broken hint must not break startup, the segment is scanned instead.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(db.hintPath(sealed[0].id), []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db = openSmall(t, tmp, CompactionConfig{})
+	check()
+	if data, err := os.ReadFile(db.hintPath(sealed[0].id)); err != nil || string(data) == "garbage" {
+		t.Errorf("Expected the broken hint file to be rewritten")
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = openSmall(t, tmp, CompactionConfig{})
+	if !db.files[0].hinted {
+		t.Error("Merged segment was not loaded from its hint file")
+	}
+	check()
+}
@@ -0,0 +1,206 @@
+package datastore
+
+import (
+	"hash/fnv"
+	"iter"
+)
+
+// index maps every key to the position of its latest record and keeps the
+// keys in order. It is only changed while db.mu is held for writing.
+type index interface {
+	len() int
+	get(key string) (recordPos, bool)
+	set(key string, pos recordPos)
+	delete(key string) bool
+	ascend(start, end string) iter.Seq2[string, recordPos]
+	// view returns a read-only copy that keeps seeing the current contents
+	// while the index changes. It can be used without holding db.mu and has
+	// to be released.
+	view() index
+	// release frees what the index holds on to. The index cannot be used
+	// after that.
+	release()
+	// err returns the first failure of an index kept outside of memory.
+	err() error
+}
+
+// treap is the in-memory index that keeps keys in order. It is persistent:
+// an update copies the nodes on the path to the changed key and never
+// modifies published nodes, so a copy of the treap value keeps seeing the
+// contents it had at the time it was made.
+type treap struct {
+	root *treapNode
+	size int
+}
+
+type treapNode struct {
+	key         string
+	pos         recordPos
+	priority    uint64
+	left, right *treapNode
+}
+
+// priority is derived from the key, so the shape of the tree only depends on
+// its contents.
+func priority(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func (t *treap) view() index {
+	c := *t
+	return &c
+}
+
+func (t *treap) release() {}
+
+func (t *treap) err() error {
+	return nil
+}
+
+func (t *treap) len() int {
+	return t.size
+}
+
+func (t *treap) get(key string) (recordPos, bool) {
+	n := t.root
+	for n != nil {
+		switch {
+		case key < n.key:
+			n = n.left
+		case key > n.key:
+			n = n.right
+		default:
+			return n.pos, true
+		}
+	}
+	return recordPos{}, false
+}
+
+func (t *treap) set(key string, pos recordPos) {
+	var added bool
+	t.root, added = insert(t.root, key, pos, priority(key))
+	if added {
+		t.size++
+	}
+}
+
+func (t *treap) delete(key string) bool {
+	var removed bool
+	t.root, removed = remove(t.root, key)
+	if removed {
+		t.size--
+	}
+	return removed
+}
+
+func insert(n *treapNode, key string, pos recordPos, prio uint64) (*treapNode, bool) {
+	if n == nil {
+		return &treapNode{key: key, pos: pos, priority: prio}, true
+	}
+
+	c := *n
+	var added bool
+	switch {
+	case key < n.key:
+		c.left, added = insert(n.left, key, pos, prio)
+		// The returned child is a fresh copy, so it can be rotated in place.
+		if c.left.priority > c.priority {
+			l := c.left
+			c.left = l.right
+			l.right = &c
+			return l, added
+		}
+	case key > n.key:
+		c.right, added = insert(n.right, key, pos, prio)
+		if c.right.priority > c.priority {
+			r := c.right
+			c.right = r.left
+			r.left = &c
+			return r, added
+		}
+	default:
+		c.pos = pos
+	}
+	return &c, added
+}
+
+func remove(n *treapNode, key string) (*treapNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	var removed bool
+	c := *n
+	switch {
+	case key < n.key:
+		c.left, removed = remove(n.left, key)
+	case key > n.key:
+		c.right, removed = remove(n.right, key)
+	default:
+		return merge(n.left, n.right), true
+	}
+	if !removed {
+		return n, false
+	}
+	return &c, true
+}
+
+// merge joins two treaps where every key of a is less than every key of b.
+func merge(a, b *treapNode) *treapNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		c := *a
+		c.right = merge(a.right, b)
+		return &c
+	}
+	c := *b
+	c.left = merge(a, b.left)
+	return &c
+}
+
+// ascend yields the keys in [start, end) in order. An empty end means there
+// is no upper bound.
+func (t *treap) ascend(start, end string) iter.Seq2[string, recordPos] {
+	return func(yield func(string, recordPos) bool) {
+		ascendNode(t.root, start, end, yield)
+	}
+}
+
+func ascendNode(n *treapNode, start, end string, yield func(string, recordPos) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.key >= start {
+		if !ascendNode(n.left, start, end, yield) {
+			return false
+		}
+	}
+	if end != "" && n.key >= end {
+		return false
+	}
+	if n.key >= start && !yield(n.key, n.pos) {
+		return false
+	}
+	return ascendNode(n.right, start, end, yield)
+}
+
+// PrefixEnd returns the smallest key that is greater than every key with the
+// given prefix, to be used as the end of a Scan. It returns "" when there is
+// no such key.
+func PrefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package datastore
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestTreap(t *testing.T) {
+	var tr treap
+	expected := make(map[string]int64)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key%03d", rnd.Intn(500))
+		if rnd.Intn(4) == 0 {
+			_, had := expected[key]
+			if tr.delete(key) != had {
+				t.Fatalf("delete(%q) disagrees with the reference map", key)
+			}
+			delete(expected, key)
+			continue
+		}
+		tr.set(key, recordPos{offset: int64(i)})
+		expected[key] = int64(i)
+	}
+
+	if tr.len() != len(expected) {
+		t.Errorf("len() = %d, wanted %d", tr.len(), len(expected))
+	}
+	var keys []string
+	for key, pos := range tr.ascend("", "") {
+		if pos.offset != expected[key] {
+			t.Errorf("Position of %q is %d, wanted %d", key, pos.offset, expected[key])
+		}
+		keys = append(keys, key)
+	}
+	if !slices.IsSorted(keys) || len(keys) != len(expected) {
+		t.Errorf("ascend does not yield every key in order")
+	}
+
+	var ranged []string
+	for key := range tr.ascend("key100", "key200") {
+		ranged = append(ranged, key)
+	}
+	for _, key := range ranged {
+		if key < "key100" || key >= "key200" {
+			t.Errorf("Key %q is out of range", key)
+		}
+	}
+}
+
+func TestTreapPersistence(t *testing.T) {
+	var tr treap
+	for i := 0; i < 100; i++ {
+		tr.set(fmt.Sprintf("k%02d", i), recordPos{offset: int64(i)})
+	}
+	old := tr
+	for i := 0; i < 100; i += 2 {
+		tr.delete(fmt.Sprintf("k%02d", i))
+	}
+	tr.set("k01", recordPos{offset: 1000})
+
+	if old.len() != 100 {
+		t.Errorf("Old version changed its size to %d", old.len())
+	}
+	if pos, ok := old.get("k01"); !ok || pos.offset != 1 {
+		t.Errorf("Old version sees %v, %v for k01", pos, ok)
+	}
+	if _, ok := old.get("k00"); !ok {
+		t.Error("Old version lost a deleted key")
+	}
+	if tr.len() != 50 {
+		t.Errorf("New version has %d keys, wanted 50", tr.len())
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, expected := range map[string]string{
+		"team/": "team0",
+		"a":     "b",
+		"a\xff": "b",
+		"\xff":  "",
+		"":      "",
+	} {
+		if got := PrefixEnd(prefix); got != expected {
+			t.Errorf("PrefixEnd(%q) = %q, wanted %q", prefix, got, expected)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package datastore
+
+import (
+	"io"
+	"iter"
+	"path/filepath"
+	"strconv"
+)
+
+// SegmentPaths returns the live segment files in dir in the order they have
+// to be replayed in, as listed by the manifest.
+func SegmentPaths(dir string) ([]string, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(m.ids))
+	for i, id := range m.ids {
+		paths[i] = filepath.Join(dir, baseFilename+strconv.Itoa(id))
+	}
+	return paths, nil
+}
+
+// Position locates a record inside a segment file.
+type Position struct {
+	Offset int64
+	Size   int64
+	// Saved is how many bytes compression saved on the record, zero if the
+	// value is stored as is.
+	Saved int64
+}
+
+// SegmentReader walks the records of a single segment file without opening
+// the database, for tools that inspect or repair the files.
+type SegmentReader struct {
+	sc *scanner
+}
+
+func NewSegmentReader(r io.ReaderAt) (*SegmentReader, error) {
+	sc, err := newScanner(r)
+	if err != nil {
+		return nil, err
+	}
+	return &SegmentReader{sc}, nil
+}
+
+// Version returns the format version of the segment, 0 for files written
+// before segments had a header.
+func (r *SegmentReader) Version() byte {
+	return r.sc.version
+}
+
+// Records yields every valid record together with its position. It stops at
+// the end of data or at the first damaged record, see End and Err.
+func (r *SegmentReader) Records() iter.Seq2[Position, kvPair] {
+	return func(yield func(Position, kvPair) bool) {
+		for pos, pair := range r.sc.entries() {
+			saved := encodedSize(pair, r.sc.version) - pos.size
+			if !yield(Position{pos.offset, pos.size, saved}, pair) {
+				return
+			}
+		}
+	}
+}
+
+// End returns the offset right after the last valid record read so far.
+func (r *SegmentReader) End() int64 {
+	return r.sc.end
+}
+
+// Err tells why Records stopped early. It is nil at a clean end of data.
+func (r *SegmentReader) Err() error {
+	return r.sc.err
+}
+
+// Damaged tells whether Err is a torn or corrupted record rather than an
+// I/O failure. A damaged segment can be repaired by truncating it at End.
+func (r *SegmentReader) Damaged() bool {
+	return isDamagedTail(r.sc.err)
+}
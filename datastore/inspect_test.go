@@ -0,0 +1,63 @@
+package datastore
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSegmentReader(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := SegmentPaths(dir)
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("SegmentPaths() = %v, %v", paths, err)
+	}
+	f, err := os.OpenFile(paths[0], os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("torn"), info.Size()); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewSegmentReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var positions []Position
+	var deleted []bool
+	for pos, pair := range r.Records() {
+		if pair.Key() != "a" {
+			t.Errorf("Unexpected key %q", pair.Key())
+		}
+		positions = append(positions, pos)
+		deleted = append(deleted, pair.Deleted())
+	}
+	if len(positions) != 2 || deleted[0] || !deleted[1] {
+		t.Fatalf("Unexpected records %v, deleted %v", positions, deleted)
+	}
+	if positions[1].Offset != positions[0].Offset+positions[0].Size {
+		t.Errorf("Records are not adjacent: %v", positions)
+	}
+	if !r.Damaged() || r.End() != info.Size() {
+		t.Errorf("Expected a damaged tail at %d, got end %d, err %v", info.Size(), r.End(), r.Err())
+	}
+}
@@ -0,0 +1,170 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// The manifest lists the live segments of a directory in replay order,
+// together with the next free segment id. A merged segment gets a fresh id,
+// so the order does not follow the ids:
+//
+//	magic | version | next id | segment count | segment ids... | crc32
+//
+// It is replaced atomically whenever the set of segments changes, so Open
+// never has to guess the order from file names and never replays a file that
+// compaction has already merged away.
+const (
+	manifestName        = "MANIFEST"
+	manifestMagic       = "KVM"
+	manifestFormat byte = 1
+
+	manifestHeaderSize = len(manifestMagic) + 1 + 8 + 4
+)
+
+var errBadManifest = errors.New("bad manifest file")
+
+type manifest struct {
+	ids  []int
+	next int
+}
+
+func encodeManifest(m manifest) []byte {
+	buf := make([]byte, 0, manifestHeaderSize+8*len(m.ids)+4)
+	buf = append(buf, manifestMagic...)
+	buf = append(buf, manifestFormat)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(m.next))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(m.ids)))
+	for _, id := range m.ids {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(id))
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.Checksum(buf, crcTable))
+}
+
+func decodeManifest(data []byte) (manifest, error) {
+	if len(data) < manifestHeaderSize+4 {
+		return manifest{}, errBadManifest
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(sum) {
+		return manifest{}, errBadManifest
+	}
+	if string(body[:len(manifestMagic)]) != manifestMagic || body[len(manifestMagic)] != manifestFormat {
+		return manifest{}, errBadManifest
+	}
+
+	m := manifest{next: int(binary.LittleEndian.Uint64(body[4:12]))}
+	count := int(binary.LittleEndian.Uint32(body[12:16]))
+	body = body[manifestHeaderSize:]
+	if len(body) != 8*count {
+		return manifest{}, errBadManifest
+	}
+	for i := 0; i < count; i++ {
+		id := int(binary.LittleEndian.Uint64(body[8*i:]))
+		if id < 0 || id >= m.next || slices.Contains(m.ids, id) {
+			return manifest{}, errBadManifest
+		}
+		m.ids = append(m.ids, id)
+	}
+	return m, nil
+}
+
+// readManifest returns the segments of dir in replay order. Directories
+// written before the manifest existed have none, every segment file found
+// there is live.
+func readManifest(dir string) (manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err == nil {
+		return decodeManifest(data)
+	}
+	if !os.IsNotExist(err) {
+		return manifest{}, err
+	}
+	return manifestFromFiles(dir)
+}
+
+// manifestFromFiles lists every segment file found in dir.
+func manifestFromFiles(dir string) (manifest, error) {
+	ids, err := segmentIDs(dir)
+	if err != nil {
+		return manifest{}, err
+	}
+	m := manifest{ids: ids}
+	if len(ids) > 0 {
+		m.next = ids[len(ids)-1] + 1
+	}
+	return m, nil
+}
+
+// writeManifest atomically replaces the manifest of dir and makes the change
+// durable.
+func writeManifest(dir string, m manifest, perm os.FileMode) error {
+	path := filepath.Join(dir, manifestName)
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, encodeManifest(m), perm); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func writeSynced(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir flushes the directory entry changes made by a rename.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// saveManifest records the current segments. Callers must hold db.mu for
+// writing.
+func (db *Database) saveManifest() error {
+	m := manifest{ids: make([]int, len(db.files)), next: db.nextID}
+	for i, seg := range db.files {
+		m.ids[i] = seg.id
+	}
+	return writeManifest(db.dir, m, db.opts.fileMode)
+}
+
+// removeUnlisted deletes segment files the manifest does not know about: a
+// segment created right before a crash or one whose compaction was recorded
+// but not yet cleaned up.
+func removeUnlisted(dir string, m manifest) error {
+	ids, err := segmentIDs(dir)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if !slices.Contains(m.ids, id) {
+			if err := os.Remove(filepath.Join(dir, baseFilename+strconv.Itoa(id))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
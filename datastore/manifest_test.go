@@ -0,0 +1,130 @@
+package datastore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestManifestEncoding(t *testing.T) {
+	m := manifest{ids: []int{10, 2, 11}, next: 12}
+	data := encodeManifest(m)
+
+	decoded, err := decodeManifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, m) {
+		t.Errorf("Unexpected manifest %+v", decoded)
+	}
+
+	data[5] ^= 0xff
+	if _, err := decodeManifest(data); err == nil {
+		t.Error("Expected a corrupted manifest to be rejected")
+	}
+	if _, err := decodeManifest(encodeManifest(manifest{ids: []int{3, 2, 3}, next: 4})); err == nil {
+		t.Error("Expected a repeated segment to be rejected")
+	}
+}
+
+// writeSegment creates a segment file holding the given records.
+func writeSegment(t *testing.T, dir string, id int, pairs ...kvPair) {
+	t.Helper()
+	data := []byte(segmentMagic + string(currentFormat))
+	for _, pair := range pairs {
+		data = append(data, Serialize(pair)...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, baseFilename+strconv.Itoa(id)), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManifestMigration(t *testing.T) {
+	tmp := t.TempDir()
+	writeSegment(t, tmp, 2, kvPair{key: "key", value: "old"})
+	writeSegment(t, tmp, 10, kvPair{key: "key", value: "new"})
+
+	db, err := Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if value, err := db.Get("key"); err != nil || value != "new" {
+		t.Errorf("Get(key) = %q, %v, wanted the value of segment 10", value, err)
+	}
+
+	m, err := readManifest(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.ids, []int{2, 10}) || m.next != 11 {
+		t.Errorf("Unexpected manifest after migration %+v", m)
+	}
+}
+
+func TestManifestUnlistedSegment(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "live"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A segment compaction had already merged away when the process died.
+	writeSegment(t, tmp, 5, kvPair{key: "key", value: "stale"})
+
+	db, err = Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if value, err := db.Get("key"); err != nil || value != "live" {
+		t.Errorf("Get(key) = %q, %v", value, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, baseFilename+"5")); !os.IsNotExist(err) {
+		t.Errorf("Expected the unlisted segment to be removed, got %v", err)
+	}
+}
+
+func TestManifestFollowsSegments(t *testing.T) {
+	tmp := t.TempDir()
+	db := openSmall(t, tmp, CompactionConfig{})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	value := string(make([]byte, 300))
+	for i := 0; i < 20; i++ {
+		if err := db.Put("key"+strconv.Itoa(i%3), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := readManifest(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.mu.RLock()
+	var ids []int
+	for _, seg := range db.files {
+		ids = append(ids, seg.id)
+	}
+	db.mu.RUnlock()
+	if !reflect.DeepEqual(m.ids, ids) || m.next != db.nextID {
+		t.Errorf("Manifest %+v does not match segments %v", m, ids)
+	}
+}
@@ -0,0 +1,228 @@
+package datastore
+
+import (
+	"errors"
+	"iter"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("engine is closed")
+
+// MemoryEngine is an Engine that keeps the data in a map and loses it on
+// Close. It is meant for tests that do not need the files.
+type MemoryEngine struct {
+	mu      sync.RWMutex
+	entries map[string]memoryEntry
+	seq     uint64
+	closed  bool
+}
+
+type memoryEntry struct {
+	value Value
+	seq   uint64
+	// expiresAt is a Unix time in nanoseconds, zero if the key never
+	// expires.
+	expiresAt int64
+}
+
+func (e memoryEntry) expired(now int64) bool {
+	return e.expiresAt != 0 && now >= e.expiresAt
+}
+
+// keepExpiry gives pair the expiry of the entry it replaces.
+func (e memoryEntry) keepExpiry(pair kvPair) kvPair {
+	pair.expiresAt = e.expiresAt
+	return pair
+}
+
+func NewMemoryEngine() *MemoryEngine {
+	return &MemoryEngine{entries: make(map[string]memoryEntry)}
+}
+
+// lookup returns the live entry of the key. Callers must hold m.mu.
+func (m *MemoryEngine) lookup(key string) (memoryEntry, bool) {
+	e, ok := m.entries[key]
+	if !ok || e.expired(time.Now().UnixNano()) {
+		return memoryEntry{}, false
+	}
+	return e, true
+}
+
+// apply writes the pairs. Callers must hold m.mu for writing.
+func (m *MemoryEngine) apply(pairs []kvPair) error {
+	if m.closed {
+		return ErrClosed
+	}
+	for _, pair := range pairs {
+		if pair.deleted {
+			delete(m.entries, pair.key)
+			continue
+		}
+		m.seq++
+		m.entries[pair.key] = memoryEntry{Value{pair.kind, pair.value}, m.seq, pair.expiresAt}
+	}
+	return nil
+}
+
+func (m *MemoryEngine) write(pairs []kvPair) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.apply(pairs)
+}
+
+func (m *MemoryEngine) Get(key string) (string, error) {
+	value, err := m.GetValue(key)
+	if err != nil {
+		return "", err
+	}
+	return value.AsString()
+}
+
+func (m *MemoryEngine) GetValue(key string) (Value, error) {
+	value, _, err := m.GetWithVersion(key)
+	return value, err
+}
+
+func (m *MemoryEngine) GetWithVersion(key string) (Value, uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.lookup(key)
+	if !ok {
+		return Value{}, 0, ErrKeyMissing
+	}
+	return e.value, e.seq, nil
+}
+
+func (m *MemoryEngine) Put(key, value string) error {
+	return m.PutValue(key, StringValue(value))
+}
+
+func (m *MemoryEngine) PutValue(key string, value Value) error {
+	return m.write([]kvPair{value.pair(key)})
+}
+
+func (m *MemoryEngine) PutValueTTL(key string, value Value, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errBadTTL
+	}
+	pair := value.pair(key)
+	pair.expiresAt = time.Now().Add(ttl).UnixNano()
+	return m.write([]kvPair{pair})
+}
+
+func (m *MemoryEngine) ExpiresAt(key string) (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.lookup(key)
+	if !ok {
+		return time.Time{}, ErrKeyMissing
+	}
+	if e.expiresAt == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, e.expiresAt), nil
+}
+
+func (m *MemoryEngine) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.lookup(key); !ok {
+		return ErrKeyMissing
+	}
+	return m.apply([]kvPair{{key: key, deleted: true}})
+}
+
+func (m *MemoryEngine) Increment(key string, delta int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result int64
+	e, ok := m.lookup(key)
+	if ok {
+		n, err := e.value.AsInt64()
+		if err != nil {
+			return 0, err
+		}
+		result = n
+	}
+	result, err := addInt64(result, delta)
+	if err != nil {
+		return 0, err
+	}
+	return result, m.apply([]kvPair{e.keepExpiry(Int64Value(result).pair(key))})
+}
+
+func (m *MemoryEngine) CompareAndSwap(key string, old, next Value) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.lookup(key)
+	if !ok {
+		return ErrKeyMissing
+	}
+	if e.value != old {
+		return ErrConflict
+	}
+	return m.apply([]kvPair{e.keepExpiry(next.pair(key))})
+}
+
+func (m *MemoryEngine) CompareVersionAndSwap(key string, version uint64, next Value) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.lookup(key)
+	if !ok {
+		return ErrKeyMissing
+	}
+	if e.seq != version {
+		return ErrConflict
+	}
+	return m.apply([]kvPair{e.keepExpiry(next.pair(key))})
+}
+
+func (m *MemoryEngine) NewBatch() *Batch {
+	return &Batch{commit: m.write}
+}
+
+// Scan has the semantics of Database.Scan: the keys are fixed when the
+// iteration starts and values are read as it goes.
+func (m *MemoryEngine) Scan(start, end string) iter.Seq2[string, Value] {
+	return func(yield func(string, Value) bool) {
+		m.mu.RLock()
+		var keys []string
+		for key := range m.entries {
+			if key >= start && (end == "" || key < end) {
+				keys = append(keys, key)
+			}
+		}
+		m.mu.RUnlock()
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			value, err := m.GetValue(key)
+			if err == ErrKeyMissing {
+				continue
+			}
+			if err != nil || !yield(key, value) {
+				return
+			}
+		}
+	}
+}
+
+func (m *MemoryEngine) Stats() (Stats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var size int64
+	for key, e := range m.entries {
+		size += int64(len(key) + len(e.value.data))
+	}
+	return Stats{Keys: len(m.entries), Size: size}, nil
+}
+
+func (m *MemoryEngine) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	clear(m.entries)
+	return nil
+}
@@ -0,0 +1,82 @@
+package datastore
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMemoryEngine(t *testing.T) {
+	m := NewMemoryEngine()
+	t.Cleanup(func() {
+		_ = m.Close()
+	})
+
+	if err := m.Put("b", "1"); err != nil {
+		t.Fatal(err)
+	}
+	_, v1, _ := m.GetWithVersion("b")
+	if err := m.NewBatch().Put("a", "2").Put("c", "3").Delete("b").Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("b"); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Expected the batch to delete b, got %v", err)
+	}
+	if err := m.Put("b", "4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, v2, _ := m.GetWithVersion("b"); v2 <= v1 {
+		t.Errorf("Version did not grow: %d after %d", v2, v1)
+	}
+
+	var keys []string
+	for key := range m.Scan("a", "c") {
+		keys = append(keys, key)
+	}
+	if !slices.Equal(keys, []string{"a", "b"}) {
+		t.Errorf("Scan(a, c) = %v", keys)
+	}
+
+	if n, err := m.Increment("n", 5); err != nil || n != 5 {
+		t.Errorf("Increment() = %d, %v", n, err)
+	}
+	if _, err := m.Increment("a", 1); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if _, err := m.Increment("n", math.MaxInt64); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected ErrOverflow, got %v", err)
+	}
+	if err := m.CompareAndSwap("n", Int64Value(4), Int64Value(6)); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if err := m.CompareAndSwap("n", Int64Value(5), Int64Value(6)); err != nil {
+		t.Error(err)
+	}
+	_, version, _ := m.GetWithVersion("n")
+	if err := m.CompareVersionAndSwap("n", version-1, Int64Value(7)); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if err := m.CompareVersionAndSwap("n", version, Int64Value(7)); err != nil {
+		t.Error(err)
+	}
+
+	if err := m.PutValueTTL("session", StringValue("x"), 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if at, err := m.ExpiresAt("session"); err != nil || at.IsZero() {
+		t.Errorf("ExpiresAt(session) = %v, %v", at, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := m.Get("session"); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Expected the key to expire, got %v", err)
+	}
+	if err := m.Delete("missing"); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Expected ErrKeyMissing, got %v", err)
+	}
+
+	if stats, err := m.Stats(); err != nil || stats.Keys != 5 {
+		t.Errorf("Stats() = %+v, %v", stats, err)
+	}
+}
@@ -0,0 +1,155 @@
+package datastore
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+type options struct {
+	segmentSize   int64
+	readers       int
+	queueDepth    int
+	fileMode      os.FileMode
+	compaction    CompactionConfig
+	sync          SyncMode
+	syncInterval  time.Duration
+	sweepInterval time.Duration
+	// compressThreshold is the smallest value size that is compressed, zero
+	// disables compression.
+	compressThreshold int
+	// cacheSize is the byte limit of the value cache, zero disables it.
+	cacheSize int64
+	// diskIndex keeps the index in run files with indexCache bytes of them
+	// cached in memory. indexMemLimit is the number of recent changes kept
+	// in memory before they are written out.
+	diskIndex     bool
+	indexCache    int64
+	indexMemLimit int
+}
+
+func defaultOptions() options {
+	return options{
+		segmentSize:   maxSize,
+		readers:       readerLimit,
+		queueDepth:    queueDepth,
+		fileMode:      0o600,
+		compaction:    DefaultCompaction,
+		sync:          SyncNone,
+		syncInterval:  time.Second,
+		sweepInterval: time.Minute,
+		indexMemLimit: indexMemLimit,
+	}
+}
+
+func (o options) validate() error {
+	switch {
+	case o.segmentSize <= segmentHeaderSize:
+		return errors.New("segment size is too small")
+	case o.readers <= 0:
+		return errors.New("at least one reader is required")
+	case o.queueDepth < 0:
+		return errors.New("queue depth must not be negative")
+	case o.syncInterval <= 0:
+		return errors.New("sync interval must be positive")
+	case o.sweepInterval <= 0:
+		return errors.New("sweep interval must be positive")
+	case o.compressThreshold < 0:
+		return errors.New("compression threshold must not be negative")
+	case o.cacheSize < 0:
+		return errors.New("cache size must not be negative")
+	case o.indexCache < 0:
+		return errors.New("index cache size must not be negative")
+	case o.indexMemLimit <= 0:
+		return errors.New("index memory limit must be positive")
+	}
+	return nil
+}
+
+// Option changes a setting of the database opened with OpenWithOptions.
+type Option func(*options)
+
+// WithSegmentSize sets the size after which the active segment is sealed and
+// a new one is started.
+func WithSegmentSize(size int64) Option {
+	return func(o *options) {
+		o.segmentSize = size
+	}
+}
+
+// WithReaders sets the number of goroutines serving Get.
+func WithReaders(n int) Option {
+	return func(o *options) {
+		o.readers = n
+	}
+}
+
+// WithQueueDepth sets how many read and write requests can wait for a free
+// handler before the callers block.
+func WithQueueDepth(n int) Option {
+	return func(o *options) {
+		o.queueDepth = n
+	}
+}
+
+// WithFileMode sets the permissions of the segment files the database creates.
+func WithFileMode(perm os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = perm
+	}
+}
+
+func WithCompaction(cfg CompactionConfig) Option {
+	return func(o *options) {
+		o.compaction = cfg
+	}
+}
+
+// WithSync sets how written records are flushed to disk. The interval is
+// only used by SyncPeriodic.
+func WithSync(mode SyncMode, interval time.Duration) Option {
+	return func(o *options) {
+		o.sync = mode
+		if interval > 0 {
+			o.syncInterval = interval
+		}
+	}
+}
+
+// WithSweepInterval sets how often expired keys are removed from the index.
+// Every sweep looks at the next part of the index, not all of it.
+func WithSweepInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.sweepInterval = d
+	}
+}
+
+// WithCompression makes the database deflate values of at least threshold
+// bytes before writing them. Values that do not get smaller are stored as
+// they are. Zero, the default, disables compression. Records written either
+// way stay readable whatever the setting.
+func WithCompression(threshold int) Option {
+	return func(o *options) {
+		o.compressThreshold = threshold
+	}
+}
+
+// WithCacheSize keeps recently read values in memory, up to size bytes of
+// keys and values. Zero, the default, disables the cache.
+func WithCacheSize(size int64) Option {
+	return func(o *options) {
+		o.cacheSize = size
+	}
+}
+
+// WithDiskIndex keeps the positions of the keys in files in the database
+// directory instead of memory, with up to cacheSize bytes of them cached.
+// Memory use then stays flat as the number of keys grows, at the cost of
+// disk reads on lookups that miss the cache. The files are rebuilt from the
+// segments on every Open.
+func WithDiskIndex(cacheSize int64) Option {
+	return func(o *options) {
+		o.diskIndex = true
+		o.indexCache = cacheSize
+	}
+}
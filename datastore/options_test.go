@@ -0,0 +1,56 @@
+package datastore
+
+import (
+	"os"
+	"testing"
+)
+
+func TestOpenWithOptions(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := OpenWithOptions(tmp,
+		WithSegmentSize(32),
+		WithReaders(1),
+		WithQueueDepth(0),
+		WithFileMode(0o640),
+		WithCompaction(CompactionConfig{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for _, pair := range [][]string{{"k1", "v1"}, {"k2", "v2"}, {"k3", "v3"}, {"k4", "v4"}} {
+		if err := db.Put(pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if value, err := db.Get("k1"); err != nil || value != "v1" {
+		t.Errorf("Get(k1) = %q, %v", value, err)
+	}
+	if len(db.files) < 2 {
+		t.Errorf("Expected small segments to rotate, got %d files", len(db.files))
+	}
+
+	info, err := os.Stat(db.files[0].file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&^0o640 != 0 {
+		t.Errorf("Unexpected segment permissions %o", perm)
+	}
+}
+
+func TestOpenWithInvalidOptions(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"segment size": WithSegmentSize(0),
+		"readers":      WithReaders(0),
+		"queue depth":  WithQueueDepth(-1),
+	} {
+		if db, err := OpenWithOptions(t.TempDir(), opt); err == nil {
+			_ = db.Close()
+			t.Errorf("Expected an error for invalid %s", name)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"iter"
+	"slices"
+	"time"
+)
+
+// LogPosition is a place in the segment log: a segment id and an offset in
+// that segment. Offset zero stands for the first record of the segment.
+type LogPosition struct {
+	Segment int
+	Offset  int64
+}
+
+// ErrPositionGone is returned by ReadLog when the log at the position has
+// been rewritten by compaction or was never seen by this process. A follower
+// has to copy a snapshot before it can tail the log again.
+var ErrPositionGone = errors.New("log position is no longer available")
+
+// LogChunk holds complete records read from the log of a leader.
+type LogChunk struct {
+	// Version is the record format of Data.
+	Version byte
+	Data    []byte
+	// Next is where the following chunk starts.
+	Next LogPosition
+}
+
+// ReadLog returns up to limit bytes of records that follow pos. Batches are
+// never split, so a chunk can exceed limit to hold a large batch whole. An
+// empty chunk with Next equal to pos means the follower has caught up.
+func (db *Database) ReadLog(pos LogPosition, limit int) (LogChunk, error) {
+	// The read lock keeps compaction from closing the segment underneath.
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	i := slices.IndexFunc(db.files, func(seg *segment) bool {
+		return seg.id == pos.Segment
+	})
+	if i < 0 || !db.files[i].replicable {
+		return LogChunk{}, ErrPositionGone
+	}
+	seg := db.files[i]
+	offset := pos.Offset
+	if offset == 0 {
+		offset = segmentHeaderSize
+	}
+	if offset < segmentHeaderSize || offset > seg.end {
+		return LogChunk{}, ErrPositionGone
+	}
+
+	chunk := LogChunk{Version: seg.version, Next: LogPosition{seg.id, offset}}
+	if offset == seg.end {
+		if i < len(db.files)-1 {
+			chunk.Next = LogPosition{Segment: db.files[i+1].id}
+		}
+		return chunk, nil
+	}
+
+	data, err := readRecords(seg, offset, min(seg.end-offset, int64(limit)))
+	if err == nil && len(data) == 0 {
+		data, err = readRecords(seg, offset, seg.end-offset)
+	}
+	if err != nil {
+		return LogChunk{}, err
+	}
+	chunk.Data = data
+	chunk.Next.Offset += int64(len(data))
+	return chunk, nil
+}
+
+// readRecords reads n bytes at offset and cuts them after the last complete
+// batch.
+func readRecords(seg *segment, offset, n int64) ([]byte, error) {
+	buf := make([]byte, n)
+	if err := readFull(seg.file, buf, offset); err != nil {
+		return nil, err
+	}
+	sc := &scanner{r: bytes.NewReader(buf), version: seg.version}
+	for range sc.entries() {
+	}
+	if sc.err != nil && sc.err != io.ErrUnexpectedEOF {
+		return nil, sc.err
+	}
+	return buf[:sc.end], nil
+}
+
+// ApplyLog writes the records of a chunk read from a leader. They are written
+// as a single batch, so a follower never exposes a part of a leader batch.
+func (db *Database) ApplyLog(chunk LogChunk) error {
+	sc := &scanner{r: bytes.NewReader(chunk.Data), version: chunk.Version}
+	var pairs []kvPair
+	for _, pair := range sc.entries() {
+		pairs = append(pairs, pair)
+	}
+	if sc.err != nil {
+		return sc.err
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	return db.write(writeRequest{pairs: pairs})
+}
+
+// WriteRecords writes every live record of the snapshot to w in the current
+// record format. ReplaceRecords on another database reads them back.
+func (s *Snapshot) WriteRecords(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, pos := range s.records.ascend("", "") {
+		if pos.expired(s.at) {
+			continue
+		}
+		pair, err := s.load(pos)
+		if err != nil {
+			return err
+		}
+		if _, err := bw.Write(Serialize(pair)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Position returns the end of the log at the moment the snapshot was taken.
+// Tailing the log from there picks up exactly the changes the snapshot
+// misses.
+func (s *Snapshot) Position() LogPosition {
+	return s.pos
+}
+
+// replaceBatchSize is how many bytes of records ReplaceRecords collects
+// before writing them.
+const replaceBatchSize = 1 << 20
+
+// ReplaceRecords makes the contents of the database equal to the records
+// read from r, as written by Snapshot.WriteRecords. Keys missing from r are
+// deleted. The records come in key order, so they are merged with the keys of
+// the database as they are read and nothing is collected in memory. The change
+// is not atomic: readers see it progress.
+func (db *Database) ReplaceRecords(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	db.mu.RLock()
+	records := db.records.view()
+	db.mu.RUnlock()
+	defer records.release()
+	now := time.Now().UnixNano()
+	next, stop := iter.Pull2(func(yield func(string, recordPos) bool) {
+		for key, pos := range records.ascend("", "") {
+			if !pos.expired(now) && !yield(key, pos) {
+				return
+			}
+		}
+	})
+	defer stop()
+	local, _, more := next()
+
+	var (
+		pairs []kvPair
+		size  int
+	)
+	add := func(pair kvPair, n int) error {
+		pairs = append(pairs, pair)
+		if size += n; size < replaceBatchSize {
+			return nil
+		}
+		err := db.write(writeRequest{pairs: pairs})
+		pairs, size = nil, 0
+		return err
+	}
+
+	var last string
+	for i := 0; ; i++ {
+		pair, n, err := readRecord(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if i > 0 && pair.key <= last {
+			return errors.New("records are not in key order")
+		}
+		last = pair.key
+
+		for ; more && local < pair.key; local, _, more = next() {
+			if err := add(kvPair{key: local, deleted: true}, len(local)); err != nil {
+				return err
+			}
+		}
+		if more && local == pair.key {
+			local, _, more = next()
+		}
+		if err := add(pair, n); err != nil {
+			return err
+		}
+	}
+	for ; more; local, _, more = next() {
+		if err := add(kvPair{key: local, deleted: true}, len(local)); err != nil {
+			return err
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	return db.write(writeRequest{pairs: pairs})
+}
+
+// readRecord reads the next record in the current format from a stream. It
+// returns io.EOF only if the stream ends before a record starts.
+func readRecord(r io.Reader) (kvPair, int, error) {
+	fixed := headerSize(currentFormat)
+	header := make([]byte, fixed)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return kvPair{}, 0, err
+	}
+	size, err := recordSize(header, currentFormat)
+	if err != nil {
+		return kvPair{}, 0, err
+	}
+
+	buf := make([]byte, size)
+	copy(buf, header)
+	if _, err := io.ReadFull(r, buf[fixed:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return kvPair{}, 0, err
+	}
+	pair, err := decodeRecord(buf, currentFormat)
+	return pair, len(buf), err
+}
@@ -0,0 +1,130 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// catchUp copies the log of leader to follower starting at pos and returns
+// the position it stopped at.
+func catchUp(t *testing.T, leader, follower *Database, pos LogPosition, limit int) LogPosition {
+	t.Helper()
+	for {
+		chunk, err := leader.ReadLog(pos, limit)
+		if err != nil {
+			t.Fatalf("ReadLog(%+v): %s", pos, err)
+		}
+		if err := follower.ApplyLog(chunk); err != nil {
+			t.Fatal(err)
+		}
+		if len(chunk.Data) == 0 && chunk.Next == pos {
+			return pos
+		}
+		pos = chunk.Next
+	}
+}
+
+func TestReplication(t *testing.T) {
+	leader := openSmall(t, t.TempDir(), CompactionConfig{})
+	t.Cleanup(func() {
+		_ = leader.Close()
+	})
+	follower, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = follower.Close()
+	})
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 20; i++ {
+		if err := leader.Put(fmt.Sprintf("key%d", i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pos := catchUp(t, leader, follower, LogPosition{Segment: leader.files[0].id}, 300)
+
+	err = leader.NewBatch().Delete("key0").Put("key1", "changed").Put("new", "x").Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos = catchUp(t, leader, follower, pos, 300)
+
+	if got, want := follower.Keys(""), leader.Keys(""); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Follower keys %v, leader keys %v", got, want)
+	}
+	if got, err := follower.Get("key1"); err != nil || got != "changed" {
+		t.Errorf("Follower Get(key1) = %q, %v", got, err)
+	}
+
+	if err := leader.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leader.ReadLog(LogPosition{Segment: leader.files[0].id}, 300); err != ErrPositionGone {
+		t.Errorf("Expected ErrPositionGone for a compacted segment, got %v", err)
+	}
+	if _, err := leader.ReadLog(pos, 300); err != nil {
+		t.Errorf("Position in the active segment is lost after compaction: %v", err)
+	}
+}
+
+func TestReplaceRecords(t *testing.T) {
+	leader, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = leader.Close()
+	})
+	follower, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = follower.Close()
+	})
+
+	if err := leader.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.PutInt64("n", 7); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"0", "m", "stale"} {
+		if err := follower.Put(key, "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snap := leader.Snapshot()
+	defer snap.Release()
+	var buf bytes.Buffer
+	if err := snap.WriteRecords(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.ReplaceRecords(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(follower.Keys(""), ","); got != "a,n" {
+		t.Errorf("Follower keys after ReplaceRecords: %s", got)
+	}
+	if n, err := follower.GetInt64("n"); err != nil || n != 7 {
+		t.Errorf("GetInt64(n) = %d, %v", n, err)
+	}
+	unordered := append(Serialize(kvPair{key: "b", value: "2"}), Serialize(kvPair{key: "a", value: "1"})...)
+	if err := follower.ReplaceRecords(bytes.NewReader(unordered)); err == nil {
+		t.Error("Expected records out of key order to be rejected")
+	}
+
+	if err := leader.Put("b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	catchUp(t, leader, follower, snap.Position(), 1024)
+	if got, err := follower.Get("b"); err != nil || got != "2" {
+		t.Errorf("Get(b) after tailing from the snapshot position = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,48 @@
+package datastore
+
+import (
+	"iter"
+	"time"
+)
+
+// Keys returns the keys that start with prefix in ascending order.
+func (db *Database) Keys(prefix string) []string {
+	db.mu.RLock()
+	records := db.records.view()
+	db.mu.RUnlock()
+	defer records.release()
+
+	now := time.Now().UnixNano()
+	var keys []string
+	for key, pos := range records.ascend(prefix, PrefixEnd(prefix)) {
+		if pos.expired(now) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Scan yields keys in [start, end) in ascending order together with their
+// values. An empty end means there is no upper bound. The set of keys is
+// fixed when the iteration starts, values are read as the iteration goes:
+// keys deleted in the meantime are skipped. Iteration stops at the first
+// value that cannot be read.
+func (db *Database) Scan(start, end string) iter.Seq2[string, Value] {
+	return func(yield func(string, Value) bool) {
+		db.mu.RLock()
+		records := db.records.view()
+		db.mu.RUnlock()
+		defer records.release()
+
+		for key := range records.ascend(start, end) {
+			result := db.read(key)
+			if result.err == ErrKeyMissing {
+				continue
+			}
+			if result.err != nil || !yield(key, result.value) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package datastore
+
+import (
+	"errors"
+	"iter"
+	"slices"
+	"time"
+)
+
+var ErrReleased = errors.New("snapshot is released")
+
+// Snapshot is a read-only view of the database as it was when the snapshot
+// was taken. Writes, deletes and compactions that happen later are not
+// visible through it. A snapshot pins the segments it reads from, so it has
+// to be released once it is no longer needed.
+type Snapshot struct {
+	db       *Database
+	records  index
+	segments []*segment
+	// at is the creation time, expiry is checked against it.
+	at int64
+	// pos is the end of the log the snapshot reflects.
+	pos      LogPosition
+	released bool
+}
+
+// Snapshot returns a consistent view of the current contents.
+func (db *Database) Snapshot() *Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	segments := slices.Clone(db.files)
+	for _, seg := range segments {
+		seg.refs++
+	}
+	active := segments[len(segments)-1]
+	return &Snapshot{
+		db:       db,
+		records:  db.records.view(),
+		segments: segments,
+		at:       time.Now().UnixNano(),
+		pos:      LogPosition{active.id, active.end},
+	}
+}
+
+// Release unpins the segments of the snapshot, so compaction can reclaim
+// them. The snapshot cannot be read after that. Calling Release more than
+// once has no effect.
+func (s *Snapshot) Release() {
+	db := s.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	s.records.release()
+
+	for _, seg := range s.segments {
+		seg.refs--
+		if seg.refs == 0 && seg.retired {
+			_ = seg.file.Close()
+			db.retired = slices.DeleteFunc(db.retired, func(r *segment) bool {
+				return r == seg
+			})
+		}
+	}
+}
+
+// Len returns the number of keys in the snapshot, expired ones included.
+func (s *Snapshot) Len() int {
+	return s.records.len()
+}
+
+func (s *Snapshot) Get(key string) (string, error) {
+	value, err := s.GetValue(key)
+	if err != nil {
+		return "", err
+	}
+	return value.AsString()
+}
+
+func (s *Snapshot) GetValue(key string) (Value, error) {
+	pos, ok := s.records.get(key)
+	if !ok || pos.expired(s.at) {
+		return Value{}, ErrKeyMissing
+	}
+	return s.read(pos)
+}
+
+func (s *Snapshot) read(pos recordPos) (Value, error) {
+	e, err := s.load(pos)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{e.kind, e.value}, nil
+}
+
+// load goes through the handle of the segment rather than its name: the name
+// may already belong to a merged segment.
+func (s *Snapshot) load(pos recordPos) (kvPair, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.released {
+		return kvPair{}, ErrReleased
+	}
+
+	return readAt(pos)
+}
+
+// Keys returns the keys of the snapshot that start with prefix in ascending
+// order.
+func (s *Snapshot) Keys(prefix string) []string {
+	var keys []string
+	for key, pos := range s.records.ascend(prefix, PrefixEnd(prefix)) {
+		if !pos.expired(s.at) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Scan yields the keys of the snapshot in [start, end) in ascending order
+// together with their values. An empty end means there is no upper bound.
+// Iteration stops at the first value that cannot be read.
+func (s *Snapshot) Scan(start, end string) iter.Seq2[string, Value] {
+	return func(yield func(string, Value) bool) {
+		for key, pos := range s.records.ascend(start, end) {
+			if pos.expired(s.at) {
+				continue
+			}
+			value, err := s.read(pos)
+			if err != nil || !yield(key, value) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Put(key, "old-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snap := db.Snapshot()
+
+	if err := db.Put("a", "new-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("d", "new-d"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for key, value := range snap.Scan("", "") {
+		s, _ := value.AsString()
+		got = append(got, key+"="+s)
+	}
+	if want := "a=old-a b=old-b c=old-c"; strings.Join(got, " ") != want {
+		t.Errorf("Snapshot contents %v, wanted %s", got, want)
+	}
+	if _, err := snap.Get("d"); err != ErrKeyMissing {
+		t.Errorf("Expected ErrKeyMissing for a key written after the snapshot, got %v", err)
+	}
+	if got, err := db.Get("a"); err != nil || got != "new-a" {
+		t.Errorf("Get(a) = %q, %v", got, err)
+	}
+
+	snap.Release()
+	snap.Release()
+	if _, err := snap.Get("a"); err != ErrReleased {
+		t.Errorf("Expected ErrReleased after Release, got %v", err)
+	}
+}
+
+func TestSnapshotPinsCompactedSegments(t *testing.T) {
+	db := openSmall(t, t.TempDir(), CompactionConfig{})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 30; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i%5), fmt.Sprintf("%s-%d", value, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snap := db.Snapshot()
+	for i := 0; i < 5; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), "changed"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	db.mu.RLock()
+	retired := len(db.retired)
+	db.mu.RUnlock()
+	if retired == 0 {
+		t.Fatal("Expected compacted segments to stay open while a snapshot uses them")
+	}
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key%d", i)
+		want := fmt.Sprintf("%s-%d", value, 25+i)
+		if got, err := snap.Get(key); err != nil || got != want {
+			t.Errorf("Snapshot Get(%s) = %q, %v", key, got, err)
+		}
+	}
+
+	snap.Release()
+	db.mu.RLock()
+	retired = len(db.retired)
+	db.mu.RUnlock()
+	if retired != 0 {
+		t.Errorf("Expected released segments to be closed, %d still open", retired)
+	}
+}
@@ -0,0 +1,90 @@
+package datastore
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// SyncMode defines when Put waits for the data to reach the disk.
+type SyncMode int
+
+const (
+	// SyncNone leaves flushing to the operating system.
+	SyncNone SyncMode = iota
+	// SyncAlways calls fsync after every write before acknowledging it.
+	SyncAlways
+	// SyncBatch writes all queued requests and acknowledges them after a
+	// single fsync (group commit).
+	SyncBatch
+	// SyncPeriodic calls fsync in the background once per interval. Writes
+	// made since the last sync can be lost on power failure.
+	SyncPeriodic
+)
+
+var syncModeNames = map[SyncMode]string{
+	SyncNone:     "none",
+	SyncAlways:   "always",
+	SyncBatch:    "batch",
+	SyncPeriodic: "periodic",
+}
+
+func (m SyncMode) String() string {
+	if name, ok := syncModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("SyncMode(%d)", int(m))
+}
+
+func ParseSyncMode(s string) (SyncMode, error) {
+	for mode, name := range syncModeNames {
+		if name == s {
+			return mode, nil
+		}
+	}
+	return SyncNone, fmt.Errorf("unknown sync mode %q", s)
+}
+
+// maxGroupCommit limits how many write requests share a single fsync.
+const maxGroupCommit = 128
+
+// pendingWrites appends the write requests that are already queued to the
+// batch without waiting for new ones.
+func (db *Database) pendingWrites(batch []writeRequest) []writeRequest {
+	for len(batch) < maxGroupCommit {
+		select {
+		case req, ok := <-db.writeChan:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, req)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (db *Database) syncActive() error {
+	db.mu.RLock()
+	latest := db.files[len(db.files)-1]
+	db.mu.RUnlock()
+	return latest.file.Sync()
+}
+
+func (db *Database) periodicSync() {
+	defer db.bgWg.Done()
+
+	ticker := time.NewTicker(db.opts.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.syncActive(); err != nil {
+				log.Printf("datastore: periodic sync failed: %s", err)
+			}
+		case <-db.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncModes(t *testing.T) {
+	for _, mode := range []SyncMode{SyncNone, SyncAlways, SyncBatch, SyncPeriodic} {
+		t.Run(mode.String(), func(t *testing.T) {
+			tmp := t.TempDir()
+			db, err := OpenWithOptions(tmp, WithSync(mode, 10*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			for w := 0; w < 10; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 20; i++ {
+						if err := db.Put(fmt.Sprintf("k%d-%d", w, i), fmt.Sprintf("v%d", i)); err != nil {
+							t.Error(err)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			db, err = OpenWithOptions(tmp, WithSync(mode, 10*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				_ = db.Close()
+			})
+
+			for w := 0; w < 10; w++ {
+				key := fmt.Sprintf("k%d-19", w)
+				if value, err := db.Get(key); err != nil || value != "v19" {
+					t.Errorf("Get(%q) = %q, %v", key, value, err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSyncMode(t *testing.T) {
+	for _, mode := range []SyncMode{SyncNone, SyncAlways, SyncBatch, SyncPeriodic} {
+		parsed, err := ParseSyncMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Errorf("ParseSyncMode(%q) = %v, %v", mode.String(), parsed, err)
+		}
+	}
+	if _, err := ParseSyncMode("sometimes"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
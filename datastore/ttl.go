@@ -0,0 +1,113 @@
+package datastore
+
+import (
+	"errors"
+	"time"
+)
+
+var errBadTTL = errors.New("ttl must be positive")
+
+// PutValueTTL writes the value so that it disappears once ttl has passed.
+// A key that has expired reads as ErrKeyMissing until it is written again.
+func (db *Database) PutValueTTL(key string, value Value, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errBadTTL
+	}
+	pair := value.pair(key)
+	pair.expiresAt = time.Now().Add(ttl).UnixNano()
+	return db.write(writeRequest{pairs: []kvPair{pair}})
+}
+
+func (db *Database) PutWithTTL(key, value string, ttl time.Duration) error {
+	return db.PutValueTTL(key, StringValue(value), ttl)
+}
+
+// ExpiresAt returns when the key expires, the zero time if it never does.
+func (db *Database) ExpiresAt(key string) (time.Time, error) {
+	pos, ok := db.lookup(key)
+	if !ok {
+		return time.Time{}, ErrKeyMissing
+	}
+	if pos.expiresAt == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, pos.expiresAt), nil
+}
+
+// sweepStep is the number of keys one periodic sweep looks at. A sweep
+// goes on where the previous one stopped, so a large disk index is not read
+// in full every time.
+const sweepStep = 1 << 16
+
+// expireHandler periodically drops expired keys from the index, so they do
+// not pile up in memory when nobody reads them.
+func (db *Database) expireHandler() {
+	defer db.bgWg.Done()
+
+	ticker := time.NewTicker(db.opts.sweepInterval)
+	defer ticker.Stop()
+	var from string
+	for {
+		select {
+		case <-ticker.C:
+			from = db.removeExpired(from, sweepStep)
+		case <-db.done:
+			return
+		}
+	}
+}
+
+type liveRecord struct {
+	key string
+	pos recordPos
+}
+
+// removeExpired deletes expired keys from the index and accounts their
+// records as garbage. It looks at up to limit keys starting with from, or
+// at all of them if limit is zero, and returns the key to start the next
+// sweep with, empty once the end of the index is reached. The keys are
+// collected under the read lock, so readers are only held up while they are
+// being deleted.
+func (db *Database) removeExpired(from string, limit int) string {
+	now := time.Now().UnixNano()
+
+	db.mu.RLock()
+	if db.expiring == 0 {
+		db.mu.RUnlock()
+		return ""
+	}
+	records := db.records.view()
+	db.mu.RUnlock()
+	defer records.release()
+	var (
+		expired []liveRecord
+		next    string
+		seen    int
+	)
+	for key, pos := range records.ascend(from, "") {
+		if limit > 0 && seen == limit {
+			next = key
+			break
+		}
+		seen++
+		if pos.expired(now) {
+			expired = append(expired, liveRecord{key, pos})
+		}
+	}
+	if len(expired) == 0 {
+		return next
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, rec := range expired {
+		// Skip keys written again in the meantime.
+		if cur, ok := db.records.get(rec.key); ok && cur == rec.pos {
+			rec.pos.seg.garbage += rec.pos.size
+			db.records.delete(rec.key)
+			db.expiring--
+			db.cache.remove(rec.key)
+		}
+	}
+	return next
+}
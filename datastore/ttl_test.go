@@ -0,0 +1,226 @@
+package datastore
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTTL(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithSweepInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.PutWithTTL("session", "data", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("kept", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Get("session"); err != nil || got != "data" {
+		t.Fatalf("Get(session) = %q, %v before expiry", got, err)
+	}
+	if at, err := db.ExpiresAt("session"); err != nil || time.Until(at) <= 0 || time.Until(at) > 50*time.Millisecond {
+		t.Errorf("ExpiresAt(session) = %v, %v", at, err)
+	}
+	if at, err := db.ExpiresAt("kept"); err != nil || !at.IsZero() {
+		t.Errorf("ExpiresAt(kept) = %v, %v, wanted the zero time", at, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := db.Get("session"); err != ErrKeyMissing {
+		t.Errorf("Expected ErrKeyMissing for an expired key, got %v", err)
+	}
+	if err := db.Delete("session"); err != ErrKeyMissing {
+		t.Errorf("Expected ErrKeyMissing when deleting an expired key, got %v", err)
+	}
+	if keys := db.Keys(""); len(keys) != 1 || keys[0] != "kept" {
+		t.Errorf("Keys() = %v, expired key is still listed", keys)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := db.records.get("session"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Sweeper did not remove the expired key")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAtomicKeepsTTL(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMemoryEngine()
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = m.Close()
+	})
+
+	for name, e := range map[string]Engine{"Database": db, "MemoryEngine": m} {
+		if err := e.PutValueTTL("rate", Int64Value(1), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		want, err := e.ExpiresAt("rate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Increment("rate", 1); err != nil {
+			t.Fatal(err)
+		}
+		if at, err := e.ExpiresAt("rate"); err != nil || !at.Equal(want) {
+			t.Errorf("%s: ExpiresAt(rate) = %v, %v after Increment, wanted %v", name, at, err, want)
+		}
+		if err := e.CompareAndSwap("rate", Int64Value(2), Int64Value(3)); err != nil {
+			t.Fatal(err)
+		}
+		if at, err := e.ExpiresAt("rate"); err != nil || !at.Equal(want) {
+			t.Errorf("%s: ExpiresAt(rate) = %v, %v after CompareAndSwap, wanted %v", name, at, err, want)
+		}
+		_, version, err := e.GetWithVersion("rate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.CompareVersionAndSwap("rate", version, Int64Value(4)); err != nil {
+			t.Fatal(err)
+		}
+		if at, err := e.ExpiresAt("rate"); err != nil || !at.Equal(want) {
+			t.Errorf("%s: ExpiresAt(rate) = %v, %v after CompareVersionAndSwap, wanted %v", name, at, err, want)
+		}
+	}
+
+	if err := db.PutValueTTL("short", Int64Value(1), 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := db.Increment("short", 1); err != nil {
+		t.Fatal(err)
+	}
+	if at, err := db.ExpiresAt("short"); err != nil || !at.IsZero() {
+		t.Errorf("ExpiresAt(short) = %v, %v, an expired key should start over without a TTL", at, err)
+	}
+}
+
+func TestTTLRestore(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("short", "v", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("long", "v", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	db, err = Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if _, err := db.Get("short"); err != ErrKeyMissing {
+		t.Errorf("Expected ErrKeyMissing for a key that expired while closed, got %v", err)
+	}
+	if got, err := db.Get("long"); err != nil || got != "v" {
+		t.Errorf("Get(long) = %q, %v", got, err)
+	}
+}
+
+func TestCompactionDropsExpired(t *testing.T) {
+	db := openSmall(t, t.TempDir(), CompactionConfig{})
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 20; i++ {
+		if err := db.PutWithTTL(fmt.Sprintf("key%d", i), value, 50*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutWithTTL("kept", value, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for item := range Stream(db.files[0].file) {
+		keys = append(keys, item.key)
+	}
+	if len(keys) > 1 {
+		t.Errorf("Expected expired keys to be dropped from the merged segment, got %v", keys)
+	}
+	if got, err := db.Get("kept"); err != nil || got != value {
+		t.Errorf("Get(kept) = %q, %v", got, err)
+	}
+	if _, err := db.Get("key0"); err != ErrKeyMissing {
+		t.Errorf("Expected ErrKeyMissing for an expired key, got %v", err)
+	}
+}
+
+func TestSweepSteps(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), WithSweepInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.Put("a", "kept"); err != nil {
+		t.Fatal(err)
+	}
+	if next := db.removeExpired("", 1); next != "" {
+		t.Errorf("A sweep without expiring keys went on to %q", next)
+	}
+
+	for _, key := range []string{"b", "c", "d", "e"} {
+		if err := db.PutWithTTL(key, "v", 10*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutWithTTL("f", "v", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	var stops []string
+	for next := db.removeExpired("", 2); next != ""; next = db.removeExpired(next, 2) {
+		stops = append(stops, next)
+	}
+	if !slices.Equal(stops, []string{"c", "e"}) {
+		t.Errorf("Sweeps stopped at %v, wanted [c e]", stops)
+	}
+	if keys := db.Keys(""); !slices.Equal(keys, []string{"a", "f"}) {
+		t.Errorf("Keys() = %v after the sweeps", keys)
+	}
+	if _, ok := db.records.get("b"); ok {
+		t.Error("The sweeps did not remove the expired keys from the index")
+	}
+	db.mu.RLock()
+	expiring := db.expiring
+	db.mu.RUnlock()
+	if expiring != 1 {
+		t.Errorf("%d keys are counted as expiring, wanted 1", expiring)
+	}
+}
@@ -0,0 +1,117 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ValueType tells how the bytes of a stored value are interpreted.
+type ValueType byte
+
+const (
+	TypeString ValueType = iota
+	TypeInt64
+	TypeBytes
+)
+
+var valueTypeNames = map[ValueType]string{
+	TypeString: "string",
+	TypeInt64:  "int64",
+	TypeBytes:  "bytes",
+}
+
+func (t ValueType) String() string {
+	if name, ok := valueTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ValueType(%d)", int(t))
+}
+
+func ParseValueType(s string) (ValueType, error) {
+	for t, name := range valueTypeNames {
+		if name == s {
+			return t, nil
+		}
+	}
+	return TypeString, fmt.Errorf("unknown value type %q", s)
+}
+
+var ErrWrongType = errors.New("wrong value type")
+
+// Value is a stored value together with its type.
+type Value struct {
+	Type ValueType
+	data string
+}
+
+func StringValue(s string) Value {
+	return Value{TypeString, s}
+}
+
+func Int64Value(n int64) Value {
+	return Value{TypeInt64, string(binary.LittleEndian.AppendUint64(nil, uint64(n)))}
+}
+
+func BytesValue(b []byte) Value {
+	return Value{TypeBytes, string(b)}
+}
+
+func (v Value) pair(key string) kvPair {
+	return kvPair{key: key, value: v.data, kind: v.Type}
+}
+
+func (v Value) check(want ValueType) error {
+	if v.Type != want {
+		return fmt.Errorf("%w: value is %s, not %s", ErrWrongType, v.Type, want)
+	}
+	return nil
+}
+
+func (v Value) AsString() (string, error) {
+	if err := v.check(TypeString); err != nil {
+		return "", err
+	}
+	return v.data, nil
+}
+
+func (v Value) AsInt64() (int64, error) {
+	if err := v.check(TypeInt64); err != nil {
+		return 0, err
+	}
+	if len(v.data) != 8 {
+		return 0, ErrCorrupted
+	}
+	return int64(binary.LittleEndian.Uint64([]byte(v.data))), nil
+}
+
+func (v Value) AsBytes() ([]byte, error) {
+	if err := v.check(TypeBytes); err != nil {
+		return nil, err
+	}
+	return []byte(v.data), nil
+}
+
+func (db *Database) GetInt64(key string) (int64, error) {
+	value, err := db.GetValue(key)
+	if err != nil {
+		return 0, err
+	}
+	return value.AsInt64()
+}
+
+func (db *Database) PutInt64(key string, n int64) error {
+	return db.PutValue(key, Int64Value(n))
+}
+
+func (db *Database) GetBytes(key string) ([]byte, error) {
+	value, err := db.GetValue(key)
+	if err != nil {
+		return nil, err
+	}
+	return value.AsBytes()
+}
+
+func (db *Database) PutBytes(key string, b []byte) error {
+	return db.PutValue(key, BytesValue(b))
+}
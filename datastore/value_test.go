@@ -0,0 +1,58 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTypedValues(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.Put("str", "text"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutInt64("num", -42); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutBytes("raw", []byte{0, 1, 2, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		t.Helper()
+		if n, err := db.GetInt64("num"); err != nil || n != -42 {
+			t.Errorf("GetInt64(num) = %d, %v", n, err)
+		}
+		if b, err := db.GetBytes("raw"); err != nil || !bytes.Equal(b, []byte{0, 1, 2, 0xff}) {
+			t.Errorf("GetBytes(raw) = %v, %v", b, err)
+		}
+		if s, err := db.Get("str"); err != nil || s != "text" {
+			t.Errorf("Get(str) = %q, %v", s, err)
+		}
+	}
+	check()
+
+	if _, err := db.Get("num"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType reading an int64 as a string, got %v", err)
+	}
+	if _, err := db.GetInt64("str"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType reading a string as an int64, got %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
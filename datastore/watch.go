@@ -0,0 +1,191 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+const (
+	// watchHistory is how many recent events are kept for watchers that
+	// resume after a disconnect.
+	watchHistory = 4096
+	// watchQueue is how many events a watcher can fall behind before it is
+	// dropped.
+	watchQueue = 4096
+)
+
+var (
+	ErrSeqUnavailable = errors.New("sequence number is no longer available")
+	ErrWatchLagged    = errors.New("watcher fell behind")
+	ErrWatchClosed    = errors.New("watcher is closed")
+)
+
+// Event describes a committed record. Seq is the sequence number stored with
+// the record, so it keeps growing across restarts.
+type Event struct {
+	Seq     uint64
+	Key     string
+	Value   Value
+	Deleted bool
+}
+
+// Watcher receives the events of keys with a given prefix.
+type Watcher struct {
+	db     *Database
+	prefix string
+
+	mu     sync.Mutex
+	queue  []Event
+	signal chan struct{}
+	err    error
+}
+
+// watchers tracks the subscriptions and the recent events.
+type watchers struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []Event
+	active  map[*Watcher]struct{}
+}
+
+// Watch subscribes to changes of keys that start with prefix. Events with
+// sequence numbers greater than after are delivered, including the recent
+// ones that were committed before Watch was called. Pass LastSeq to only see
+// future changes. It returns ErrSeqUnavailable if some of the requested
+// events are no longer kept.
+func (db *Database) Watch(prefix string, after uint64) (*Watcher, error) {
+	ws := &db.watchers
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if after > ws.seq {
+		return nil, ErrSeqUnavailable
+	}
+	if after < ws.seq && (len(ws.history) == 0 || ws.history[0].Seq > after+1) {
+		return nil, ErrSeqUnavailable
+	}
+
+	w := &Watcher{db: db, prefix: prefix, signal: make(chan struct{}, 1)}
+	for _, e := range ws.history {
+		if e.Seq > after && strings.HasPrefix(e.Key, prefix) {
+			w.queue = append(w.queue, e)
+		}
+	}
+	if len(w.queue) > 0 {
+		w.signal <- struct{}{}
+	}
+	if ws.active == nil {
+		ws.active = make(map[*Watcher]struct{})
+	}
+	ws.active[w] = struct{}{}
+	return w, nil
+}
+
+// LastSeq returns the sequence number of the latest committed record.
+func (db *Database) LastSeq() uint64 {
+	db.watchers.mu.Lock()
+	defer db.watchers.mu.Unlock()
+	return db.watchers.seq
+}
+
+// publish hands the committed records to the watchers. It is only called by
+// the writer goroutine, so events keep the commit order.
+func (db *Database) publish(pairs []kvPair) {
+	ws := &db.watchers
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for _, pair := range pairs {
+		ws.seq = pair.seq
+		e := Event{Seq: pair.seq, Key: pair.key, Value: Value{pair.kind, pair.value}, Deleted: pair.deleted}
+		if len(ws.history) == watchHistory {
+			ws.history = ws.history[1:]
+		}
+		ws.history = append(ws.history, e)
+
+		for w := range ws.active {
+			if strings.HasPrefix(e.Key, w.prefix) && !w.push(e) {
+				delete(ws.active, w)
+			}
+		}
+	}
+}
+
+// closeWatchers ends all subscriptions when the database is closed.
+func (db *Database) closeWatchers() {
+	ws := &db.watchers
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for w := range ws.active {
+		w.stop(ErrWatchClosed)
+	}
+	ws.active = nil
+}
+
+// push queues an event and reports false if the watcher has fallen too far
+// behind and was stopped.
+func (w *Watcher) push(e Event) bool {
+	w.mu.Lock()
+	if len(w.queue) >= watchQueue {
+		w.mu.Unlock()
+		w.stop(ErrWatchLagged)
+		return false
+	}
+	w.queue = append(w.queue, e)
+	w.mu.Unlock()
+
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (w *Watcher) stop(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Next waits for the next event. Events queued before the watcher was
+// stopped are still returned, after them Next returns ErrWatchLagged,
+// ErrWatchClosed or the error of ctx.
+func (w *Watcher) Next(ctx context.Context) (Event, error) {
+	for {
+		w.mu.Lock()
+		if len(w.queue) > 0 {
+			e := w.queue[0]
+			w.queue = w.queue[1:]
+			w.mu.Unlock()
+			return e, nil
+		}
+		err := w.err
+		w.mu.Unlock()
+		if err != nil {
+			return Event{}, err
+		}
+
+		select {
+		case <-w.signal:
+		case <-ctx.Done():
+			return Event{}, ctx.Err()
+		}
+	}
+}
+
+// Close ends the subscription.
+func (w *Watcher) Close() {
+	ws := &w.db.watchers
+	ws.mu.Lock()
+	delete(ws.active, w)
+	ws.mu.Unlock()
+	w.stop(ErrWatchClosed)
+}
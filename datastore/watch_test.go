@@ -0,0 +1,119 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, w *Watcher) Event {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e, err := w.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestWatch(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	w, err := db.Watch("user/", db.LastSeq())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := db.Put("other", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("user/1", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("user/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := nextEvent(t, w)
+	if e.Key != "user/1" || e.Deleted || e.Value != StringValue("alice") || e.Seq != 2 {
+		t.Errorf("Unexpected first event %+v", e)
+	}
+	e = nextEvent(t, w)
+	if e.Key != "user/1" || !e.Deleted || e.Seq != 3 {
+		t.Errorf("Unexpected second event %+v", e)
+	}
+
+	// A watcher that resumes after the first event gets the rest again.
+	resumed, err := db.Watch("", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if e := nextEvent(t, resumed); e.Seq != 2 {
+		t.Errorf("Resumed watcher starts at %d, wanted 2", e.Seq)
+	}
+
+	if _, err := db.Watch("", 100); err != ErrSeqUnavailable {
+		t.Errorf("Expected ErrSeqUnavailable for a future sequence number, got %v", err)
+	}
+}
+
+func TestWatchLagged(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	w, err := db.Watch("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := db.NewBatch()
+	for i := 0; i <= watchQueue; i++ {
+		batch.Put("key", "value")
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < watchQueue; i++ {
+		if _, err := w.Next(ctx); err != nil {
+			t.Fatalf("Event %d: %v", i, err)
+		}
+	}
+	if _, err := w.Next(ctx); err != ErrWatchLagged {
+		t.Errorf("Expected ErrWatchLagged, got %v", err)
+	}
+	if _, err := db.Watch("", 0); err != ErrSeqUnavailable {
+		t.Errorf("Expected ErrSeqUnavailable once the history is gone, got %v", err)
+	}
+}
+
+func TestWatchClosedWithDatabase(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := db.Watch("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Next(context.Background()); err != ErrWatchClosed {
+		t.Errorf("Expected ErrWatchClosed, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package shard
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+// ForwardedHeader marks a request that has already been routed. A node
+// serves it from its own datastore even if its node list disagrees.
+const ForwardedHeader = "X-Shard-Forwarded"
+
+// Client reads and writes string values on the node that owns each key. If
+// its node list is out of date, the node it reaches forwards the request.
+type Client struct {
+	ring *Ring
+	http *http.Client
+}
+
+func NewClient(ring *Ring, client *http.Client) *Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Client{ring, client}
+}
+
+// Node returns the address of the node that owns the key.
+func (c *Client) Node(key string) string {
+	return c.ring.Node(key)
+}
+
+func (c *Client) Get(key string) (string, error) {
+	resp, err := c.do(http.MethodGet, key, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return "", err
+	}
+
+	var entry struct{ Value string }
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		return "", err
+	}
+	return entry.Value, nil
+}
+
+func (c *Client) Put(key, value string) error {
+	body, err := json.Marshal(map[string]string{"value": value})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodPost, key, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return checkStatus(resp)
+}
+
+func (c *Client) Delete(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return checkStatus(resp)
+}
+
+func (c *Client) do(method, key string, body []byte) (*http.Response, error) {
+	node := c.ring.Node(key)
+	if node == "" {
+		return nil, errors.New("no nodes")
+	}
+	req, err := http.NewRequest(method, "http://"+node+"/db/"+url.PathEscape(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.http.Do(req)
+}
+
+func checkStatus(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	}
+	return fmt.Errorf("%s replied %s", resp.Request.URL.Host, resp.Status)
+}
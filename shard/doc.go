@@ -0,0 +1,39 @@
+// Package shard spreads keys over several db nodes by consistent hashing.
+//
+// Every node and every key is hashed with FNV-1a followed by the murmur3
+// finalizer, which spreads similar node names evenly. A node is placed on the
+// ring at several points, and a key belongs to the first node point that
+// follows the hash of the key. Adding a node only
+// moves the keys that fall right before its points, roughly 1/n of them.
+//
+// Nodes and clients must use the same node list. A db started with -shards
+// forwards requests for keys it does not own to their owner, so any node can
+// serve as an entry point. Client does the routing on its own and talks to
+// the owner directly.
+//
+// # Adding a node
+//
+// Keys are copied with "dbctl rebalance", which walks GET /db of the nodes
+// and writes every key whose owner changes to the new owner. A key that
+// expires keeps the time it has left, rounded up to whole seconds.
+//
+//  1. Start the new node with the new node list in -shards. Nothing routes
+//     to it yet.
+//
+//  2. Copy the moving keys while the old list is still in use:
+//
+//     dbctl rebalance -old a:8082,b:8082 -new a:8082,b:8082,c:8082
+//
+//  3. Stop the writers, then run the same command again to copy the keys
+//     written since the previous run.
+//
+//  4. Restart the old nodes and the clients with the new list in -shards
+//     and resume the writers.
+//
+//  5. Delete the copied keys from their previous owners:
+//
+//     dbctl rebalance -old a:8082,b:8082 -new a:8082,b:8082,c:8082 -delete
+//
+// Writes made to the old owners between steps 3 and 4 would be lost, which is
+// why the writers have to wait. Reads keep working the whole time.
+package shard
@@ -0,0 +1,78 @@
+package shard
+
+import (
+	"cmp"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultReplicas is the number of points every node gets on the ring.
+const DefaultReplicas = 64
+
+// Ring maps keys to nodes. It is safe for concurrent use since it never
+// changes after NewRing.
+type Ring struct {
+	nodes  []string
+	points []point
+}
+
+type point struct {
+	hash uint32
+	node string
+}
+
+// hash is FNV-1a followed by the murmur3 finalizer. FNV alone maps names
+// that only differ in the last characters, like "node#1" and "node#2", to
+// nearby values, which leaves large arcs of the ring to a single node.
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// NewRing places every node on the ring at the given number of points. The
+// order of nodes does not matter.
+func NewRing(nodes []string, replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	r := &Ring{nodes: slices.Clone(nodes)}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			r.points = append(r.points, point{hash(node + "#" + strconv.Itoa(i)), node})
+		}
+	}
+	slices.SortFunc(r.points, func(a, b point) int {
+		// Ties are broken by name, so the order of nodes does not matter.
+		return cmp.Or(cmp.Compare(a.hash, b.hash), strings.Compare(a.node, b.node))
+	})
+	return r
+}
+
+// Nodes returns the nodes of the ring.
+func (r *Ring) Nodes() []string {
+	return slices.Clone(r.nodes)
+}
+
+// Node returns the node that owns the key, or "" if the ring is empty.
+func (r *Ring) Node(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i, _ := slices.BinarySearchFunc(r.points, h, func(p point, h uint32) int {
+		return cmp.Compare(p.hash, h)
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
@@ -0,0 +1,88 @@
+package shard
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRing_Distribution(t *testing.T) {
+	ring := NewRing([]string{"a:8082", "b:8082", "c:8082"}, 0)
+	same := NewRing([]string{"c:8082", "a:8082", "b:8082"}, 0)
+
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		counts[ring.Node(key)]++
+		require.Equal(t, ring.Node(key), same.Node(key), "order of nodes must not matter")
+	}
+	for node, n := range counts {
+		assert.Greater(t, n, 500, node)
+	}
+}
+
+func TestRing_SimilarNames(t *testing.T) {
+	// Addresses that differ in one digit still split the keys evenly.
+	for port := 40000; port < 40010; port++ {
+		a, b := fmt.Sprintf("127.0.0.1:%d", port), fmt.Sprintf("127.0.0.1:%d", port+1)
+		ring := NewRing([]string{a, b}, 0)
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if ring.Node(fmt.Sprintf("key%d", i)) == a {
+				n++
+			}
+		}
+		assert.InDelta(t, 500, n, 150, "%s and %s", a, b)
+	}
+}
+
+func TestRing_AddNodeMovesFewKeys(t *testing.T) {
+	before := NewRing([]string{"a:8082", "b:8082", "c:8082"}, 0)
+	after := NewRing([]string{"a:8082", "b:8082", "c:8082", "d:8082"}, 0)
+
+	moved := 0
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if old, cur := before.Node(key), after.Node(key); old != cur {
+			assert.Equal(t, "d:8082", cur, "keys may only move to the new node")
+			moved++
+		}
+	}
+	assert.Less(t, moved, 2000)
+	assert.Greater(t, moved, 0)
+}
+
+func TestRing_Empty(t *testing.T) {
+	assert.Equal(t, "", NewRing(nil, 0).Node("key"))
+}
+
+func TestClient(t *testing.T) {
+	stored := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/db/")
+		switch r.Method {
+		case http.MethodPost:
+			stored[key] = "value"
+		case http.MethodGet:
+			if _, ok := stored[key]; !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `{"key":%q,"value":%q}`, key, stored[key])
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(NewRing([]string{strings.TrimPrefix(srv.URL, "http://")}, 0), nil)
+	_, err := c.Get("team")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, c.Put("team", "value"))
+	got, err := c.Get("team")
+	require.NoError(t, err)
+	assert.Equal(t, "value", got)
+}